@@ -0,0 +1,148 @@
+package dbpool
+
+import (
+	"sync"
+	"time"
+)
+
+/////// Per-key circuit breaker ///////////
+
+// BreakerState - circuit breaker state of key (see WithCircuitBreaker)
+type BreakerState int
+
+const (
+	// BreakerClosed - key is served normally
+	BreakerClosed BreakerState = iota
+
+	// BreakerOpen - key fast-fails with ErrCircuitOpen until cool-down passes
+	BreakerOpen
+
+	// BreakerHalfOpen - cool-down passed, single probe request is allowed
+	BreakerHalfOpen
+)
+
+// String - returns breaker state name
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breaker - circuit breaker of key
+type breaker struct {
+	state    BreakerState
+	failures int
+
+	// time of opening or of the last probe start
+	since time.Time
+}
+
+// breakers - circuit breakers by internal key
+type breakers struct {
+	sync.Mutex
+
+	threshold int
+	coolDown  time.Duration
+	byKey     map[string]*breaker
+}
+
+// breakerAllow - returns ErrCircuitOpen if requests of key must fast-fail
+func (c *SafeDbMapCache) breakerAllow(key string) error {
+	if c.breakers == nil {
+		return nil
+	}
+
+	c.breakers.Lock()
+	defer c.breakers.Unlock()
+
+	b, found := c.breakers.byKey[key]
+	if !found || b.state == BreakerClosed {
+		return nil
+	}
+
+	// waiting for cool-down or for probe result (probe without result is repeated after cool-down)
+	if c.now().Sub(b.since) < c.breakers.coolDown {
+		return ErrCircuitOpen
+	}
+
+	b.state = BreakerHalfOpen
+	b.since = c.now()
+
+	return nil
+}
+
+// breakerReport - registers result of request to key
+func (c *SafeDbMapCache) breakerReport(key string, ok bool) {
+	if c.breakers == nil {
+		return
+	}
+
+	c.breakers.Lock()
+	defer c.breakers.Unlock()
+
+	b, found := c.breakers.byKey[key]
+
+	if ok {
+		delete(c.breakers.byKey, key)
+		return
+	}
+
+	if !found {
+		b = &breaker{}
+		c.breakers.byKey[key] = b
+	}
+
+	b.failures++
+
+	if b.state == BreakerHalfOpen || b.failures >= c.breakers.threshold {
+		b.state = BreakerOpen
+		b.since = c.now()
+	}
+}
+
+// breakerState - returns breaker state and consecutive failures of key
+func (c *SafeDbMapCache) breakerState(key string) (BreakerState, int) {
+	if c.breakers == nil {
+		return BreakerClosed, 0
+	}
+
+	c.breakers.Lock()
+	defer c.breakers.Unlock()
+
+	b, found := c.breakers.byKey[key]
+	if !found {
+		return BreakerClosed, 0
+	}
+
+	return b.state, b.failures
+}
+
+// observe - reports statement result of key to its breaker: connection errors are failures,
+// other results prove database is reachable. Returns err.
+func (c *SafeDbMapCache) observe(key string, err error) error {
+	c.breakerReport(c.hashKey(key), err == nil || !isConnError(err))
+
+	return err
+}
+
+// ReportFailure - registers connection-level failure of key database (see WithCircuitBreaker)
+func (c *SafeDbMapCache) ReportFailure(key string) {
+	c.breakerReport(c.hashKey(key), false)
+}
+
+// ReportSuccess - registers successful request to key database, closes its breaker (see WithCircuitBreaker)
+func (c *SafeDbMapCache) ReportSuccess(key string) {
+	c.breakerReport(c.hashKey(key), true)
+}
+
+// ResetBreaker - closes circuit breaker of key and resets its failures (see WithCircuitBreaker)
+func (c *SafeDbMapCache) ResetBreaker(key string) {
+	c.breakerReport(c.hashKey(key), true)
+}
@@ -0,0 +1,122 @@
+package dbpool
+
+import (
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Pool-wide connection budget ///////////
+
+// BudgetPolicy - behavior of Set and dials when connection budget is exhausted (see WithConnBudget)
+type BudgetPolicy int
+
+const (
+	// BudgetReject - new connection is not stored, ErrBudgetExceeded is returned
+	BudgetReject BudgetPolicy = iota
+
+	// BudgetEvictIdle - least recently used idle items (no connections in use) are evicted
+	// to make room, ErrBudgetExceeded is returned if it isn't enough
+	BudgetEvictIdle
+)
+
+// budgetWeight - returns budget charged for connection: its MaxOpenConns limit,
+// connection without limit takes the whole budget
+func (c *SafeDbMapCache) budgetWeight(db *sqlx.DB) int {
+	if db == nil {
+		return 0
+	}
+
+	if n := db.Stats().MaxOpenConnections; n > 0 {
+		return n
+	}
+
+	return c.budget
+}
+
+// charge - charges budget for newly referenced connection (must be called under write lock)
+func (c *SafeDbMapCache) charge(db *sqlx.DB) {
+	if c.budget <= 0 {
+		return
+	}
+
+	w := c.budgetWeight(db)
+
+	c.charged[db] = w
+	c.budgetUsed += w
+}
+
+// release - returns budget of no longer referenced connection (must be called under write lock)
+func (c *SafeDbMapCache) release(db *sqlx.DB) {
+	w, found := c.charged[db]
+	if !found {
+		return
+	}
+
+	delete(c.charged, db)
+	c.budgetUsed -= w
+}
+
+// reserveBudget - checks that value can be stored by key within budget, evicts idle items
+// to make room according to policy (must be called under write lock). Returns removed items.
+func (c *SafeDbMapCache) reserveBudget(key string, value *sqlx.DB) ([]removedItem, error) {
+	// already stored connection is charged
+	if c.budget <= 0 || c.refs[value] > 0 {
+		return nil, nil
+	}
+
+	used := c.budgetUsed + c.budgetWeight(value)
+
+	// replaced connection returns its budget
+	if old, found := c.pool.get(key); found && c.refs[old.Db] == 1 {
+		used -= c.charged[old.Db]
+	}
+
+	if used <= c.budget {
+		return nil, nil
+	}
+
+	if c.budgetPolicy != BudgetEvictIdle {
+		return nil, ErrBudgetExceeded
+	}
+
+	type candidate struct {
+		key  string
+		item PoolItem
+	}
+
+	// pinned, leased and floor items aren't evicted (see keptKeys)
+	kept := c.keptKeys()
+
+	var candidates []candidate
+	c.pool.each(func(k string, i PoolItem) {
+		if _, found := kept[k]; found || k == key || c.refs[i.Db] != 1 || i.Db.Stats().InUse > 0 {
+			return
+		}
+
+		candidates = append(candidates, candidate{key: k, item: i})
+	})
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].item.lastAccess().Before(candidates[j].item.lastAccess())
+	})
+
+	n := 0
+	for ; n < len(candidates) && used > c.budget; n++ {
+		used -= c.charged[candidates[n].item.Db]
+	}
+
+	// nothing is evicted if it doesn't make enough room
+	if used > c.budget {
+		return nil, ErrBudgetExceeded
+	}
+
+	removed := make([]removedItem, 0, n)
+	for _, cand := range candidates[:n] {
+		c.deleteItem(cand.key)
+
+		removed = append(removed, removedItem{key: cand.key, item: cand.item, reason: ReasonBudget})
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,82 @@
+package dbpool
+
+/////// Bounded pool size ///////////
+
+// FullPolicy - behavior of Set when pool is full (see WithMaxItems)
+type FullPolicy int
+
+const (
+	// EvictLRU - least recently used item is evicted to make room (pinned, leased and floor items
+	// are skipped, ErrPoolFull is returned if there is no other item, see Evict)
+	EvictLRU FullPolicy = iota
+
+	// Reject - new item is not stored, ErrPoolFull is returned
+	Reject
+)
+
+// reserveSlot - checks that new item can be stored by key within max items,
+// evicts least recently used item according to policy (must be called under write lock).
+// Returns removed items.
+func (c *SafeDbMapCache) reserveSlot(key string) ([]removedItem, error) {
+	if c.maxItems <= 0 || c.pool.len() < c.maxItems {
+		return nil, nil
+	}
+
+	// replaced item frees its slot
+	if _, found := c.pool.get(key); found {
+		return nil, nil
+	}
+
+	if c.fullPolicy == Reject {
+		return nil, ErrPoolFull
+	}
+
+	var (
+		lruKey  string
+		lruItem PoolItem
+		found   bool
+	)
+
+	kept := c.keptKeys()
+
+	c.pool.each(func(k string, i PoolItem) {
+		if _, skip := kept[k]; skip {
+			return
+		}
+
+		if !found || i.lastAccess().Before(lruItem.lastAccess()) {
+			lruKey, lruItem, found = k, i, true
+		}
+	})
+
+	if !found {
+		return nil, ErrPoolFull
+	}
+
+	c.deleteItem(lruKey)
+
+	return []removedItem{{key: lruKey, item: lruItem, reason: ReasonEvicted}}, nil
+}
+
+// rejectsNew - returns true if pool is full and new item of key would be rejected
+func (c *SafeDbMapCache) rejectsNew(key string) bool {
+	if c.maxItems <= 0 || c.fullPolicy != Reject {
+		return false
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.rejects(key)
+}
+
+// rejects - returns true if reserveSlot would reject new item of key (must be called under lock)
+func (c *SafeDbMapCache) rejects(key string) bool {
+	if c.maxItems <= 0 || c.fullPolicy != Reject {
+		return false
+	}
+
+	_, found := c.pool.get(key)
+
+	return !found && c.pool.len() >= c.maxItems
+}
@@ -0,0 +1,213 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Safe pinned connection (*sqlx.Conn) map with string in key ///////////
+
+// ConnItem - pinned single connection item (session variables, temp tables, advisory locks)
+type ConnItem struct {
+	Expiration int64
+	Duration   time.Duration
+	Created    time.Time
+
+	Conn *sqlx.Conn
+}
+
+// SafeConnMapCache - cache of pinned *sqlx.Conn connections with TTL.
+// Evicted connection is discarded: its driver connection is closed instead of being returned
+// to parent *sqlx.DB pool, so session state doesn't leak to the next caller of parent pool.
+type SafeConnMapCache struct {
+	sync.RWMutex
+
+	pool              map[string]ConnItem
+	defaultExpiration time.Duration
+	cleanupInterval   time.Duration
+	now               func() time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewConnCache - initializing a new SafeConnMapCache cache
+func NewConnCache(defaultExpiration, cleanupInterval time.Duration) *SafeConnMapCache {
+	cache := SafeConnMapCache{
+		pool:              make(map[string]ConnItem),
+		defaultExpiration: defaultExpiration,
+		cleanupInterval:   cleanupInterval,
+		now:               monotonicNow(),
+		stop:              make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go cache.GC()
+	}
+
+	return &cache
+}
+
+// Set - setting *sqlx.Conn value by key (previous connection of key is closed)
+func (c *SafeConnMapCache) Set(key string, value *sqlx.Conn, duration time.Duration) {
+	var expiration int64
+
+	c.Lock()
+
+	if duration == 0 {
+		duration = c.defaultExpiration
+	}
+
+	if duration > 0 {
+		expiration = c.now().Add(duration).UnixNano()
+	}
+
+	old, found := c.pool[key]
+
+	c.pool[key] = ConnItem{
+		Conn:       value,
+		Expiration: expiration,
+		Duration:   duration,
+		Created:    c.now(),
+	}
+
+	c.Unlock()
+
+	if found && old.Conn != value {
+		closeConn(old.Conn)
+	}
+}
+
+// Get - getting *sqlx.Conn value by key (extends item expiration)
+func (c *SafeConnMapCache) Get(key string) (*sqlx.Conn, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool[key]
+	if !found {
+		return nil, false
+	}
+
+	if item.Expiration > 0 && c.now().UnixNano() > item.Expiration {
+		return nil, false
+	}
+
+	if item.Duration > 0 {
+		item.Expiration = c.now().Add(item.Duration).UnixNano()
+	}
+	item.Created = c.now()
+
+	c.pool[key] = item
+
+	return item.Conn, true
+}
+
+// Delete - delete *sqlx.Conn value by key (connection is returned to its pool)
+func (c *SafeConnMapCache) Delete(key string) error {
+	c.Lock()
+
+	item, found := c.pool[key]
+	if !found {
+		c.Unlock()
+		return ErrKeyNotFound
+	}
+
+	delete(c.pool, key)
+
+	c.Unlock()
+
+	closeConn(item.Conn)
+
+	return nil
+}
+
+// GetItems - returns item list.
+func (c *SafeConnMapCache) GetItems() (items []string) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for k := range c.pool {
+		items = append(items, k)
+	}
+
+	return
+}
+
+// DeleteExpired - removes all expired items, returns number of removed items
+func (c *SafeConnMapCache) DeleteExpired() int {
+	now := c.now().UnixNano()
+
+	c.Lock()
+
+	var removed []*sqlx.Conn
+	for k, i := range c.pool {
+		if i.Expiration > 0 && now > i.Expiration {
+			removed = append(removed, i.Conn)
+
+			delete(c.pool, k)
+		}
+	}
+
+	c.Unlock()
+
+	for _, conn := range removed {
+		closeConn(conn)
+	}
+
+	return len(removed)
+}
+
+// GC - Garbage Collection cycle (until Shutdown)
+func (c *SafeConnMapCache) GC() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.cleanupInterval):
+		}
+
+		c.DeleteExpired()
+	}
+}
+
+// Shutdown - stops Garbage Collection and closes all connections
+func (c *SafeConnMapCache) Shutdown() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+
+	c.Lock()
+
+	removed := make([]*sqlx.Conn, 0, len(c.pool))
+	for k, i := range c.pool {
+		removed = append(removed, i.Conn)
+
+		delete(c.pool, k)
+	}
+
+	c.Unlock()
+
+	for _, conn := range removed {
+		closeConn(conn)
+	}
+}
+
+// closeConn - discards pinned connection with its session state (already closed one is ignored)
+func closeConn(conn *sqlx.Conn) {
+	// bad connection error makes database/sql close driver connection instead of reusing it
+	_ = conn.Raw(func(interface{}) error {
+		return driver.ErrBadConn
+	})
+
+	err := conn.Close()
+	if err != nil && !errors.Is(err, sql.ErrConnDone) {
+		Logger.Warningf("db connection close error: %s", err.Error())
+	}
+}
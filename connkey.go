@@ -0,0 +1,94 @@
+package dbpool
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Canonical keys built from connection parameters ///////////
+
+// ConnKey - connection target parameters used to build canonical cache key (see Canonical)
+type ConnKey struct {
+	Driver   string
+	Host     string
+	Port     int
+	Database string
+	User     string
+	Params   map[string]string
+}
+
+// secretParams - parameters never included into canonical key
+var secretParams = map[string]struct{}{
+	"password": {},
+	"passwd":   {},
+	"pwd":      {},
+}
+
+// Canonical - returns stable key of connection target: driver and host are lowercased,
+// params are sorted by lowercased name. Password params are excluded by design, so
+// connections to the same target with rotated password share key.
+func (k ConnKey) Canonical() string {
+	var b strings.Builder
+
+	b.WriteString(strings.ToLower(k.Driver))
+	b.WriteString("://")
+
+	if k.User != "" {
+		b.WriteString(url.PathEscape(k.User))
+		b.WriteString("@")
+	}
+
+	b.WriteString(strings.ToLower(k.Host))
+
+	if k.Port != 0 {
+		b.WriteString(":")
+		b.WriteString(strconv.Itoa(k.Port))
+	}
+
+	b.WriteString("/")
+	b.WriteString(url.PathEscape(k.Database))
+
+	params := make(map[string]string, len(k.Params))
+	for name, value := range k.Params {
+		name = strings.ToLower(name)
+		if _, secret := secretParams[name]; !secret {
+			params[name] = value
+		}
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i == 0 {
+			b.WriteString("?")
+		} else {
+			b.WriteString("&")
+		}
+
+		b.WriteString(url.QueryEscape(name))
+		b.WriteString("=")
+		b.WriteString(url.QueryEscape(params[name]))
+	}
+
+	return b.String()
+}
+
+// SetConn - setting *sqlx.DB value by canonical key of connection target
+func (c *SafeDbMapCache) SetConn(key ConnKey, value *sqlx.DB, duration time.Duration) {
+	c.Set(key.Canonical(), value, duration)
+}
+
+// GetConn - getting *sqlx.DB value by canonical key of connection target (extends item expiration)
+func (c *SafeDbMapCache) GetConn(key ConnKey) (*sqlx.DB, bool) {
+	return c.Get(key.Canonical())
+}
@@ -0,0 +1,69 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Connection opening retries ///////////
+
+// RetryPolicy - retry settings of connection opening (see WithConnectRetry)
+type RetryPolicy struct {
+	// MaxAttempts - max number of connect attempts (1 - no retries)
+	MaxAttempts int
+
+	// Backoff - returns delay after failed attempt (numbered from 1),
+	// exponentially growing from 100ms up to 30s if nil
+	Backoff func(attempt int) time.Duration
+}
+
+// ExponentialBackoff - returns backoff doubling delay after every attempt from minBackoff up to maxBackoff
+func ExponentialBackoff(minBackoff, maxBackoff time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		backoff := minBackoff
+		for i := 1; i < attempt && backoff < maxBackoff; i++ {
+			backoff *= 2
+		}
+
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		return backoff
+	}
+}
+
+// connectRetry - opens connection of key with connect, failed attempts are retried according
+// to retry policy (see WithConnectRetry). Waiting for next attempt is interrupted by ctx.
+func (c *SafeDbMapCache) connectRetry(ctx context.Context, key string, connect ConnectFunc) (*sqlx.DB, error) {
+	attempts := c.connectRetryPolicy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	backoff := c.connectRetryPolicy.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(defaultReconnectMinBackoff, defaultReconnectMaxBackoff)
+	}
+
+	for attempt := 1; ; attempt++ {
+		db, err := c.traceConnect(ctx, key, connect)
+		if err == nil || attempt >= attempts || ctx.Err() != nil {
+			return db, err
+		}
+
+		Logger.Warningf("db connection of key %s connect attempt %d error: %s", c.redact(key), attempt, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.stop:
+			return nil, err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package dbpool
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Uniform connection settings ///////////
+
+// ConnSettings - sqlx.DB pool settings applied to every stored connection
+// (see WithConnSettings, SetOptions.ConnSettings). Zero field - leave as is.
+type ConnSettings struct {
+	// MaxOpen - see sql.DB.SetMaxOpenConns
+	MaxOpen int
+
+	// MaxIdle - see sql.DB.SetMaxIdleConns
+	MaxIdle int
+
+	// ConnMaxLifetime - see sql.DB.SetConnMaxLifetime
+	ConnMaxLifetime time.Duration
+
+	// ConnMaxIdleTime - see sql.DB.SetConnMaxIdleTime
+	ConnMaxIdleTime time.Duration
+}
+
+// apply - applies non-zero settings to db
+func (s ConnSettings) apply(db *sqlx.DB) {
+	if db == nil {
+		return
+	}
+
+	if s.MaxOpen != 0 {
+		db.SetMaxOpenConns(s.MaxOpen)
+	}
+
+	if s.MaxIdle != 0 {
+		db.SetMaxIdleConns(s.MaxIdle)
+	}
+
+	if s.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(s.ConnMaxLifetime)
+	}
+
+	if s.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(s.ConnMaxIdleTime)
+	}
+}
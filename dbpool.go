@@ -3,8 +3,12 @@ package dbpool
 import (
 	. "github.com/NGRsoftlab/ngr-logging"
 
-	"errors"
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,202 +16,1961 @@ import (
 
 /////// Safe db pool map with string in key ///////////
 
+// MinCleanupInterval - minimal GC interval, smaller positive intervals are raised to it
+// (tiny interval would make GC spin burning CPU)
+const MinCleanupInterval = time.Millisecond
+
+// NoExpiration - duration making item permanent (see Set, UpdateTTL).
+// Zero duration is not "no cache": it always means "use cache default expiration".
+const NoExpiration time.Duration = -1
+
 type PoolItem struct {
+	// Expiration, Duration - sliding (idle) deadline and TTL, deadline is extended on every Get
 	Expiration int64
 	Duration   time.Duration
 	Created    time.Time
 
-	Db *sqlx.DB
+	// MaxExpiration, MaxDuration - absolute deadline and TTL, not extended by Get (see SetOptions.MaxTTL)
+	MaxExpiration int64
+	MaxDuration   time.Duration
+
+	// FirstCreated - time the connection was first put into cache (kept on re-Set of the same Db)
+	FirstCreated time.Time
+
+	// Metadata - item labels (tenant ID, db role, etc.), copied on Set and on read
+	Metadata map[string]string
+
+	// Db - shared connection owned by cache, must not be closed by caller (use Delete, see WithCloseGuard)
+	Db *sqlx.DB
+
+	// number of GC evictions deferred because connection was in use (see WithEvictOnlyIdle)
+	evictDeferrals int
+
+	// number of consecutive failed keepalive pings (see WithKeepAlive)
+	pingFailures int
+
+	// connect func used to reconnect dead connection (see SetOptions.Connect)
+	connect ConnectFunc
+
+	// settings applied to reconnected connection (see WithConnSettings)
+	connSettings ConnSettings
+
+	// max age overriding pool max lifetime (see SetOptions.MaxAge)
+	maxAge time.Duration
+
+	// item connection close function (see SetOptions.CloseFunc)
+	closeFunc func(db *sqlx.DB) error
+
+	// attempts of the last health check (see WithHealthCheckRetry)
+	healthChecks []HealthAttempt
+
+	// last health check failed (see MarkUnhealthy)
+	unhealthy bool
+
+	// other connections of item group (see SetGroup)
+	group *dbGroup
+
+	// item isn't removed by GC on TTL expiration (see SetOptions.Pinned)
+	pinned bool
+
+	// sliding deadline and last access time shared by item copies (see itemAccess)
+	access *itemAccess
+}
+
+// itemAccess - sliding deadline and last access time of item. Get-like reads update them
+// atomically under read lock, so Expiration and Created fields of pool items may be stale:
+// cache reads them with expiration and lastAccess, items passed outside are synced.
+type itemAccess struct {
+	expiration int64
+	accessed   int64 // unix nano
+
+	// failed health check ping is being retried in strict mode (see HealthRetry.Strict)
+	suspect int32
+}
+
+// newItemAccess - returns item access state
+func newItemAccess(expiration int64, accessed time.Time) *itemAccess {
+	return &itemAccess{expiration: expiration, accessed: accessed.UnixNano()}
+}
+
+// expiration - returns current sliding deadline of item
+func (i PoolItem) expiration() int64 {
+	if i.access == nil {
+		return i.Expiration
+	}
+
+	return atomic.LoadInt64(&i.access.expiration)
+}
+
+// lastAccess - returns last access time of item
+func (i PoolItem) lastAccess() time.Time {
+	if i.access == nil {
+		return i.Created
+	}
+
+	return time.Unix(0, atomic.LoadInt64(&i.access.accessed))
+}
+
+// setExpiration - sets sliding deadline of item
+func (i *PoolItem) setExpiration(expiration int64) {
+	i.Expiration = expiration
+
+	if i.access != nil {
+		atomic.StoreInt64(&i.access.expiration, expiration)
+	}
+}
+
+// setAccessed - sets last access time of item
+func (i *PoolItem) setAccessed(accessed time.Time) {
+	i.Created = accessed
+
+	if i.access != nil {
+		atomic.StoreInt64(&i.access.accessed, accessed.UnixNano())
+	}
+}
+
+// setSuspect - sets flag hiding item from Get-like reads (see HealthRetry.Strict)
+func (i *PoolItem) setSuspect(suspect bool) {
+	if i.access == nil {
+		return
+	}
+
+	var flag int32
+	if suspect {
+		flag = 1
+	}
+
+	atomic.StoreInt32(&i.access.suspect, flag)
+}
+
+// suspect - returns true if item is hidden from Get-like reads (see HealthRetry.Strict)
+func (i PoolItem) suspect() bool {
+	return i.access != nil && atomic.LoadInt32(&i.access.suspect) == 1
+}
+
+// touch - extends sliding expiration of item (if it has TTL) and sets its last access time to now
+func (i *PoolItem) touch(now time.Time) {
+	var newExpiration int64
+	if i.Duration > 0 {
+		newExpiration = now.Add(i.Duration).UnixNano()
+	}
+
+	i.setExpiration(newExpiration)
+	i.setAccessed(now)
+}
+
+// synced - returns item copy with current Expiration and Created (for callbacks and reports)
+func (i PoolItem) synced() PoolItem {
+	i.Expiration = i.expiration()
+	i.Created = i.lastAccess()
+
+	return i
+}
+
+// deadline - returns nearer of sliding and absolute deadlines (0 - never expires)
+func (i PoolItem) deadline() int64 {
+	expiration := i.expiration()
+
+	if i.MaxExpiration > 0 && (expiration <= 0 || i.MaxExpiration < expiration) {
+		return i.MaxExpiration
+	}
+
+	return expiration
+}
+
+// renewDeadlines - restarts both deadlines of item holding new connection
+func (i *PoolItem) renewDeadlines(now time.Time) {
+	if i.Duration > 0 {
+		i.setExpiration(now.Add(i.Duration).UnixNano())
+	}
+
+	if i.MaxDuration > 0 {
+		i.MaxExpiration = now.Add(i.MaxDuration).UnixNano()
+	}
+}
+
+// removedItem - item removed from pool and waiting for close
+type removedItem struct {
+	key    string
+	item   PoolItem
+	reason EvictReason
+}
+
+type SafeDbMapCache struct {
+	sync.RWMutex
+
+	pool              *itemMap
+	defaultExpiration time.Duration
+	cleanupInterval   time.Duration
+
+	// number of item map shards (see WithShards)
+	shards int
+
+	// number of keys referencing connection, shared connection is closed with its last key
+	refs map[*sqlx.DB]int
+
+	// number of leases holding connection and key it was leased by (see Lease)
+	leases    map[*sqlx.DB]int
+	leaseKeys map[*sqlx.DB]string
+
+	// close functions of item connections (see SetOptions.CloseFunc)
+	closers map[*sqlx.DB]func(db *sqlx.DB) error
+
+	// signaled (under write lock) when pool becomes empty (see WaitForEmpty)
+	emptied *sync.Cond
+
+	// prepared statements by connection (see PreparedQueryx)
+	stmtsMu       sync.Mutex
+	stmts         map[*sqlx.DB]*stmtCache
+	stmtCacheSize int
+
+	// time dial failures of registered keys are remembered for (see WithDialFailureTTL)
+	dialFailureTTL time.Duration
+
+	// dial rate limit and limiter state (see WithDialRateLimit)
+	dialRate   dialRate
+	dialLimits dialLimiter
+
+	// retry policy of connection opening (see WithConnectRetry)
+	connectRetryPolicy RetryPolicy
+
+	// pool-wide dial slots and number of dials waiting for slot, accessed atomically (see WithMaxConcurrentDials)
+	dialSlots  chan struct{}
+	dialQueued int64
+
+	// circuit breakers by key (see WithCircuitBreaker)
+	breakers *breakers
+
+	// connection close function (see WithCloseFunc)
+	closeFunc func(db *sqlx.DB) error
+
+	// hook called right before connection close (see WithBeforeClose)
+	beforeClose func(key string, db *sqlx.DB)
+
+	// max number of items and full pool behavior (see WithMaxItems)
+	maxItems   int
+	fullPolicy FullPolicy
+
+	// pool-wide connection budget, used part and budget charged by connection (see WithConnBudget)
+	budget       int
+	budgetPolicy BudgetPolicy
+	budgetUsed   int
+	charged      map[*sqlx.DB]int
+
+	// adaptive GC settings (see WithAdaptiveGC)
+	adaptiveGC         bool
+	minCleanupInterval time.Duration
+	maxCleanupInterval time.Duration
+
+	// currently effective GC interval, accessed atomically
+	gcInterval int64
+
+	// last GC cycle results, accessed atomically
+	lastGCDuration int64
+	lastGCEvicted  int64
+
+	// number of recovered GC sweep panics, accessed atomically (see safeGCCycle)
+	gcPanics int64
+
+	// GC sweep panic is re-raised after logging (see WithGCRepanic)
+	gcRepanic bool
+
+	// round-robin cursor of Any, accessed atomically
+	anyNext uint32
+
+	// GC pause flag, accessed atomically (see PauseGC)
+	gcPaused int32
+
+	// GC running flag, accessed atomically
+	gcRunning int32
+
+	// GC interval reset channel (see SetCleanupInterval)
+	gcReset chan struct{}
+
+	// GC immediate sweep channel (see ResumeGC)
+	gcResume chan struct{}
+
+	// GC stop channel (see Shutdown)
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// closed flag, accessed atomically (see Shutdown)
+	closed int32
+
+	// closes left to background on ctx expiration (see DeleteContext, ClearAllContext, CloseContext),
+	// Shutdown waits for them before events channel close
+	closing sync.WaitGroup
+
+	// busy connections eviction settings (see WithEvictOnlyIdle)
+	evictOnlyIdle     bool
+	maxEvictDeferrals int
+
+	// number of deferred evictions, accessed atomically
+	deferredEvictions int64
+
+	// Get-like reads counters, accessed atomically
+	hits   int64
+	misses int64
+
+	// statements retried on fresh connection and failed retries, accessed atomically (see ExecWithRetry)
+	retries       int64
+	failedRetries int64
+
+	// key redaction for debug output (see WithKeyRedactor)
+	redactKey func(key string) string
+
+	// hashed keys mode settings and display forms by hashed key (see WithHashedKeys)
+	hashKeys    func(key string) string
+	keepDisplay bool
+	displayMu   sync.RWMutex
+	display     map[string]string
+
+	// settings applied to every stored connection (see WithConnSettings)
+	connSettings ConnSettings
+
+	// clock used for expiration math (see WithClock)
+	now func() time.Time
+
+	// GC evicted items waiting for close (see WithCloseDelay)
+	closeDelay time.Duration
+	pendingMu  sync.Mutex
+	pending    map[uint64]*pendingClose
+	pendingSeq uint64
+
+	// keepalive settings (see WithKeepAlive)
+	keepAlive          time.Duration
+	keepAliveThreshold int
+
+	// namespace index: namespace -> set of full keys (see NamespaceKey)
+	namespaces map[string]map[string]struct{}
+
+	// secondary indexes by name (see WithIndex)
+	indexes map[string]*secondaryIndex
+
+	// max connection lifetime since first creation (see WithMaxLifetime)
+	maxLifetime time.Duration
+
+	// number of most recently used items kept by GC on TTL expiration (see WithMinEntries)
+	minEntries int
+
+	// GC health check settings (see WithHealthCheckOnGC)
+	healthCheckOnGC    bool
+	healthCheckTimeout time.Duration
+
+	// health check retry settings (see WithHealthCheckRetry)
+	healthRetry HealthRetry
+
+	// background health check settings (see WithHealthCheck)
+	healthInterval time.Duration
+	healthFail     FailPolicy
+
+	// preferred DSN probe interval of failed over registrations (see WithFailback)
+	failbackInterval time.Duration
+
+	// registered connection parameters by key (see RegisterDSN)
+	registryMu sync.Mutex
+	registry   map[string]*registration
+
+	// in-flight GetOrCreate factory calls by key
+	createMu sync.Mutex
+	creating map[string]*dialCall
+
+	// reconnect state by key (see GetVerified)
+	reconnectMu         sync.Mutex
+	reconnects          map[string]*reconnectCall
+	reconnectMinBackoff time.Duration
+	reconnectMaxBackoff time.Duration
+
+	// eviction callback (see WithOnEvict)
+	onEvict func(key string, item PoolItem, reason EvictReason)
+
+	// detect connections closed outside of cache (see WithCloseGuard)
+	closeGuard bool
+
+	// recent operations history (see WithHistory), nil - disabled
+	history *history
+
+	// recent item removals (see WithEvictionLog), nil - disabled
+	evictionLog *history
+
+	// connection opens and pings tracer (see WithTracer)
+	tracer Tracer
+
+	// additional eviction policy (see WithEvictionPolicy)
+	policy EvictionPolicy
+
+	// internal removal callback called last on every removal (see SafeDbMapCacheK)
+	onRemoved func(key string)
+
+	// operation hooks (see WithHooks)
+	hooks *Hooks
+
+	// eviction events channel (see Events)
+	eventBuffer   int
+	events        chan Event
+	eventsMu      sync.RWMutex
+	eventsClosed  bool
+	droppedEvents int64
+
+	// serve-stale mode settings (see WithServeStale)
+	staleTTL   time.Duration
+	refresh    RefreshFunc
+	refreshing map[string]struct{}
+}
+
+// New - initializing a new SafeDbMapCache cache.
+// defaultExpiration is used by Set with zero duration, zero or NoExpiration default - items never expire.
+// Positive cleanupInterval less than MinCleanupInterval is raised to it.
+func New(defaultExpiration, cleanupInterval time.Duration, opts ...Option) *SafeDbMapCache {
+	defaultExpiration = checkExpiration(defaultExpiration)
+	cleanupInterval = checkInterval(cleanupInterval)
+
+	// cache item
+	cache := SafeDbMapCache{
+		defaultExpiration: defaultExpiration,
+		cleanupInterval:   cleanupInterval,
+		gcReset:           make(chan struct{}, 1),
+		gcResume:          make(chan struct{}, 1),
+		refreshing:        make(map[string]struct{}),
+		now:               monotonicNow(),
+		pending:           make(map[uint64]*pendingClose),
+		stop:              make(chan struct{}),
+		namespaces:        make(map[string]map[string]struct{}),
+		reconnects:        make(map[string]*reconnectCall),
+		registry:          make(map[string]*registration),
+		creating:          make(map[string]*dialCall),
+		refs:              make(map[*sqlx.DB]int),
+		leases:            make(map[*sqlx.DB]int),
+		leaseKeys:         make(map[*sqlx.DB]string),
+		closers:           make(map[*sqlx.DB]func(db *sqlx.DB) error),
+		charged:           make(map[*sqlx.DB]int),
+		stmts:             make(map[*sqlx.DB]*stmtCache),
+		dialLimits: dialLimiter{
+			buckets: make(map[string]*dialBucket),
+			rates:   make(map[string]dialRate),
+		},
+
+		keepAliveThreshold:  defaultKeepAliveFailThreshold,
+		reconnectMinBackoff: defaultReconnectMinBackoff,
+		reconnectMaxBackoff: defaultReconnectMaxBackoff,
+		eventBuffer:         defaultEventBuffer,
+		stmtCacheSize:       defaultStmtCacheSize,
+		display:             make(map[string]string),
+		redactKey:           RedactDSN,
+		shards:              defaultShards,
+	}
+
+	for _, opt := range opts {
+		opt(&cache)
+	}
+
+	cache.pool = newItemMap(cache.shards)
+	cache.events = make(chan Event, cache.eventBuffer)
+	cache.emptied = sync.NewCond(&cache.RWMutex)
+
+	if cleanupInterval > 0 {
+		cache.gcInterval = int64(cache.clampInterval(cleanupInterval))
+
+		cache.StartGC()
+	}
+
+	if cache.keepAlive > 0 {
+		go cache.keepAliveLoop()
+	}
+
+	if cache.healthInterval > 0 {
+		go cache.healthLoop()
+	}
+
+	if cache.failbackInterval > 0 {
+		go cache.failbackLoop()
+	}
+
+	return &cache
+}
+
+// NewWithCleanup - initializing a new SafeDbMapCache cache, returns it with cleanup function
+// stopping GC and closing all connections (cache, cleanup := NewWithCleanup(...); defer cleanup())
+func NewWithCleanup(defaultExpiration, cleanupInterval time.Duration, opts ...Option) (*SafeDbMapCache, func()) {
+	cache := New(defaultExpiration, cleanupInterval, opts...)
+
+	return cache, cache.Shutdown
+}
+
+// SetDefaultExpiration - changing default expiration used by subsequent Set calls with zero duration
+func (c *SafeDbMapCache) SetDefaultExpiration(d time.Duration) {
+	d = checkExpiration(d)
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.defaultExpiration = d
+}
+
+// checkExpiration - returns valid default expiration: negative values mean NoExpiration
+func checkExpiration(d time.Duration) time.Duration {
+	if d < 0 && d != NoExpiration {
+		Logger.Warningf("db pool default expiration %s is negative, items will never expire", d)
+
+		return NoExpiration
+	}
+
+	return d
+}
+
+// checkInterval - returns valid GC interval: positive interval is at least MinCleanupInterval
+func checkInterval(d time.Duration) time.Duration {
+	if d > 0 && d < MinCleanupInterval {
+		Logger.Warningf("db pool cleanup interval %s is raised to %s", d, MinCleanupInterval)
+
+		return MinCleanupInterval
+	}
+
+	return d
+}
+
+// monotonicNow - returns default clock: wall time of the call advanced by monotonic time elapsed since,
+// so expiration math isn't affected by wall clock jumps (NTP corrections, manual changes, VM resume)
+func monotonicNow() func() time.Time {
+	start := time.Now()
+
+	return monotonicClock(start, func() time.Duration { return time.Since(start) })
+}
+
+// monotonicClock - returns clock reading start advanced by elapsed
+func monotonicClock(start time.Time, elapsed func() time.Duration) func() time.Time {
+	start = start.Round(0)
+
+	return func() time.Time {
+		return start.Add(elapsed())
+	}
+}
+
+// DefaultExpiration - returns current default expiration (see SetDefaultExpiration)
+func (c *SafeDbMapCache) DefaultExpiration() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.defaultExpiration
+}
+
+// CleanupInterval - returns configured GC interval (see SetCleanupInterval).
+// Effective interval may differ with adaptive GC, see Stats.GCInterval.
+func (c *SafeDbMapCache) CleanupInterval() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.cleanupInterval
+}
+
+// SetCleanupInterval - changing GC interval, takes effect immediately (GC timer is restarted).
+// Positive interval starts GC if it isn't running, zero or negative interval stops it.
+// Positive interval less than MinCleanupInterval is raised to it.
+func (c *SafeDbMapCache) SetCleanupInterval(d time.Duration) {
+	d = checkInterval(d)
+
+	c.Lock()
+	c.cleanupInterval = d
+	c.Unlock()
+
+	if d > 0 {
+		atomic.StoreInt64(&c.gcInterval, int64(c.clampInterval(d)))
+	} else {
+		atomic.StoreInt64(&c.gcInterval, 0)
+	}
+
+	// wake up running GC
+	select {
+	case c.gcReset <- struct{}{}:
+	default:
+	}
+
+	if d > 0 && atomic.CompareAndSwapInt32(&c.gcRunning, 0, 1) {
+		go c.GC()
+	}
+}
+
+// SetOptions - additional Set parameters (see SetWithOptions)
+type SetOptions struct {
+	// Metadata - item labels, copied into cache
+	Metadata map[string]string
+
+	// Connect - if set, dead connection is reconnected with it (see GetVerified, WithKeepAlive)
+	// and outlived connection is rotated with it (see MaxAge)
+	Connect ConnectFunc
+
+	// MaxAge - max connection age since first creation overriding pool one (see WithMaxLifetime),
+	// negative - no limit for the item
+	MaxAge time.Duration
+
+	// IdleTTL - sliding TTL extended on every Get, overrides duration argument if set
+	IdleTTL time.Duration
+
+	// MaxTTL - absolute TTL since Set, not extended by Get. Item expires when
+	// either idle or absolute deadline passes. Zero - no absolute deadline.
+	MaxTTL time.Duration
+
+	// ConnSettings - connection settings overriding pool ones (see WithConnSettings)
+	ConnSettings *ConnSettings
+
+	// Pinned - GC doesn't remove item on TTL expiration: its deadlines are renewed by GC sweep
+	// instead, keeping connection warm. Item is also skipped by Evict and health check eviction (see FailEvict),
+	// but still removed by Delete, max age and eviction policy.
+	Pinned bool
+
+	// CloseFunc - closes item connection instead of pool close function (see WithCloseFunc)
+	// on removal (Delete, GC, ClearAll, replacement, etc.), e.g. with extra teardown. It is called
+	// once per connection without cache lock, reconnected connection of item is closed with it too.
+	CloseFunc func(db *sqlx.DB) error
+}
+
+// Set - setting *sqlx.DB value by key.
+// Zero duration - use default expiration (see New), NoExpiration - item never expires.
+func (c *SafeDbMapCache) Set(key string, value *sqlx.DB, duration time.Duration) {
+	c.SetWithOptions(key, value, duration, SetOptions{})
+}
+
+// SetWithOptions - setting *sqlx.DB value by key with additional parameters.
+// Connection exceeding budget (see WithConnBudget) or not fitting full pool (see WithMaxItems)
+// is not stored, use TrySet to get the error.
+func (c *SafeDbMapCache) SetWithOptions(key string, value *sqlx.DB, duration time.Duration, opts SetOptions) {
+	err := c.TrySet(key, value, duration, opts)
+	if err != nil {
+		Logger.Errorf("db connection of key %s is not stored: %s", c.redact(c.hashKey(key)), err.Error())
+	}
+}
+
+// TrySet - setting *sqlx.DB value by key with additional parameters.
+// Returns ErrBudgetExceeded if connection doesn't fit budget (see WithConnBudget),
+// ErrPoolFull if pool is full (see WithMaxItems) and ErrClosed if cache is closed (see Shutdown),
+// connection is not stored then and stays owned by caller.
+func (c *SafeDbMapCache) TrySet(key string, value *sqlx.DB, duration time.Duration, opts SetOptions) error {
+	return c.trySet(key, value, nil, duration, opts)
+}
+
+// trySet - setting *sqlx.DB value with other connections of its group (see SetGroup, SetRoles) by key
+func (c *SafeDbMapCache) trySet(key string, value *sqlx.DB, group *dbGroup, duration time.Duration, opts SetOptions) error {
+	var expiration, maxExpiration int64
+
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	connSettings := c.connSettings
+	if opts.ConnSettings != nil {
+		connSettings = *opts.ConnSettings
+	}
+
+	connSettings.apply(value)
+	if group != nil {
+		for _, m := range group.members {
+			connSettings.apply(m)
+		}
+	}
+
+	raw := key
+	key = c.hashKey(key)
+
+	// display form is remembered before set (for logs and events of set itself) and after it,
+	// since concurrent removal of previous item could forget it meanwhile
+	c.rememberDisplay(key, raw)
+
+	c.Lock()
+
+	// Shutdown marks cache closed before its items removal under lock, so connection
+	// is either rejected here or stored before removal and closed by it
+	if c.isClosed() {
+		c.Unlock()
+
+		return ErrClosed
+	}
+
+	// rejected item mustn't cost budget evictions
+	if c.rejects(key) {
+		c.Unlock()
+
+		return ErrPoolFull
+	}
+
+	evicted, err := c.reserveBudget(key, value)
+	if err != nil {
+		c.Unlock()
+
+		return err
+	}
+
+	slot, err := c.reserveSlot(key)
+	evicted = append(evicted, slot...)
+
+	if err != nil {
+		c.Unlock()
+
+		c.closeRemoved(evicted)
+
+		return err
+	}
+
+	if opts.IdleTTL != 0 {
+		duration = opts.IdleTTL
+	}
+
+	if duration == 0 {
+		duration = c.defaultExpiration
+	}
+
+	if duration > 0 {
+		expiration = c.now().Add(duration).UnixNano()
+	}
+
+	if opts.MaxTTL > 0 {
+		maxExpiration = c.now().Add(opts.MaxTTL).UnixNano()
+	}
+
+	firstCreated := c.now()
+
+	old, found := c.pool.get(key)
+	if found && old.Db == value {
+		firstCreated = old.FirstCreated
+	}
+
+	item := PoolItem{
+		Db:            value,
+		Expiration:    expiration,
+		Duration:      duration,
+		MaxExpiration: maxExpiration,
+		MaxDuration:   opts.MaxTTL,
+		Created:       c.now(),
+		FirstCreated:  firstCreated,
+		Metadata:      copyMetadata(opts.Metadata),
+		connect:       opts.Connect,
+		connSettings:  connSettings,
+		maxAge:        opts.MaxAge,
+		closeFunc:     opts.CloseFunc,
+		group:         group,
+		pinned:        opts.Pinned,
+		access:        newItemAccess(expiration, c.now()),
+	}
+
+	c.insertItem(key, item)
+
+	c.Unlock()
+
+	c.closeRemoved(evicted)
+
+	c.rememberDisplay(key, raw)
+
+	// stored connection proves database is reachable
+	if c.dialFailureTTL > 0 {
+		c.ClearFailure(raw)
+	}
+
+	c.record(HistorySet, key)
+
+	if c.hooks != nil && c.hooks.OnSet != nil {
+		item.Metadata = copyMetadata(item.Metadata)
+		c.runHook("OnSet", func() { c.hooks.OnSet(key, item) })
+	}
+
+	// replaced connection is closed, replaced group keeps connections of the new one
+	if found && old.Db != value {
+		_ = c.closeItem(removedItem{key: key, item: old, reason: ReasonReplaced})
+	} else if found {
+		_ = c.closeMembers(key, old.groupMembers())
+	}
+
+	return nil
+}
+
+// UpdateTTL - changing item idle duration in place, expiration is recomputed from now.
+// NoExpiration makes item permanent, zero applies pool default expiration.
+func (c *SafeDbMapCache) UpdateTTL(key string, d time.Duration) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	key = c.hashKey(key)
+
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool.get(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	if d == 0 {
+		d = c.defaultExpiration
+	}
+
+	item.Duration = d
+	var expiration int64
+	if d > 0 {
+		expiration = c.now().Add(d).UnixNano()
+	}
+
+	item.setExpiration(expiration)
+
+	c.pool.set(key, item)
+
+	return nil
+}
+
+// WaitForEmpty - blocks until pool has no items (all of them are removed) or ctx is done.
+// Returns ctx error if ctx is done first.
+func (c *SafeDbMapCache) WaitForEmpty(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	// wakes up waiting loop on ctx cancellation
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Lock()
+			c.emptied.Broadcast()
+			c.Unlock()
+		case <-done:
+		}
+	}()
+
+	c.Lock()
+	defer c.Unlock()
+
+	for c.pool.len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		c.emptied.Wait()
+	}
+
+	return nil
+}
+
+// has - returns true if key is in pool (expired or not)
+func (c *SafeDbMapCache) has(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	_, found := c.pool.get(key)
+
+	return found
+}
+
+// insertItem - puts item into pool and indexes (must be called under write lock)
+func (c *SafeDbMapCache) insertItem(key string, item PoolItem) {
+	if old, found := c.pool.get(key); found {
+		c.unref(old.Db)
+
+		for _, m := range old.groupMembers() {
+			c.unref(m)
+		}
+	}
+
+	if c.refs[item.Db] == 0 {
+		c.charge(item.Db)
+	}
+
+	c.refs[item.Db]++
+	c.pool.set(key, item)
+	c.indexItem(key, item)
+
+	// group members aren't charged against budget (see WithConnBudget)
+	for _, m := range item.groupMembers() {
+		c.refs[m]++
+	}
+
+	if item.closeFunc != nil {
+		c.closers[item.Db] = item.closeFunc
+	}
+
+	c.indexNamespace(key)
+}
+
+// deleteItem - removes item from pool and indexes (must be called under write lock)
+func (c *SafeDbMapCache) deleteItem(key string) {
+	if item, found := c.pool.get(key); found {
+		c.unref(item.Db)
+
+		for _, m := range item.groupMembers() {
+			c.unref(m)
+		}
+	}
+
+	c.pool.remove(key)
+
+	c.unindexNamespace(key)
+	c.unindexItem(key)
+	c.forgetReconnect(key)
+
+	if c.pool.len() == 0 {
+		c.emptied.Broadcast()
+	}
+}
+
+// unref - drops key reference of connection (must be called under write lock)
+func (c *SafeDbMapCache) unref(db *sqlx.DB) {
+	if c.refs[db] <= 1 {
+		delete(c.refs, db)
+		c.release(db)
+
+		return
+	}
+
+	c.refs[db]--
+}
+
+// shared - returns true if connection is still referenced by some key
+func (c *SafeDbMapCache) shared(db *sqlx.DB) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.refs[db] > 0
+}
+
+// copyMetadata - returns metadata copy (nil for empty metadata)
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	res := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		res[k] = v
+	}
+
+	return res
+}
+
+// GetOptions - read mode for GetWith
+type GetOptions struct {
+	// Touch - extend item expiration (sliding TTL) on successful read
+	Touch bool
+
+	// PingCtx - if set, connection is checked with PingContext before returning.
+	// Dead connection is closed and removed from cache.
+	PingCtx context.Context
+}
+
+// GetWith - getting *sqlx.DB value by key with explicit read mode.
+// Returns ping error (if PingCtx is set and ping failed) with found == false.
+func (c *SafeDbMapCache) GetWith(key string, opts GetOptions) (*sqlx.DB, bool, error) {
+	return c.getWith(c.hashKey(key), opts)
+}
+
+// getWith - GetWith by internal key (see WithHashedKeys)
+func (c *SafeDbMapCache) getWith(key string, opts GetOptions) (*sqlx.DB, bool, error) {
+	if err := c.breakerAllow(key); err != nil {
+		return nil, false, err
+	}
+
+	// checked item is touched only after successful ping
+	db, res := c.read(key, opts.Touch && opts.PingCtx == nil)
+
+	found := res == GetHit
+	if found && c.guardClosed(key, db) {
+		db, found = nil, false
+	}
+
+	if found && opts.PingCtx != nil {
+
+		// ping to check
+		err := c.tracePing(opts.PingCtx, key, db)
+		if err != nil {
+			c.evictIfSame(key, db, ReasonPingFailed)
+			c.getHook(key, false)
+
+			return nil, false, err
+		}
+
+		if opts.Touch {
+			c.touchIfSame(key, db)
+		}
+	}
+
+	c.getHook(key, found)
+
+	return db, found, nil
+}
+
+// GetResult - TryGet result
+type GetResult int
+
+const (
+	// GetHit - item is found
+	GetHit GetResult = iota
+
+	// GetMissing - item never existed or was removed
+	GetMissing
+
+	// GetExpired - item is still in cache, but expired (waiting for GC)
+	GetExpired
+)
+
+// String - returns result name
+func (r GetResult) String() string {
+	switch r {
+	case GetHit:
+		return "hit"
+	case GetMissing:
+		return "missing"
+	case GetExpired:
+		return "expired"
+	default:
+		return "unknown"
+	}
+}
+
+// TryGet - getting *sqlx.DB value by key (extends item expiration) with miss reason
+func (c *SafeDbMapCache) TryGet(key string) (*sqlx.DB, GetResult) {
+	key = c.hashKey(key)
+
+	db, res := c.read(key, true)
+	if res == GetHit && c.guardClosed(key, db) {
+		db, res = nil, GetMissing
+	}
+
+	c.getHook(key, res == GetHit)
+
+	return db, res
+}
+
+// Get - getting *sqlx.DB value by key (extends item expiration).
+// Returned connection is shared, caller must not close it.
+func (c *SafeDbMapCache) Get(key string) (*sqlx.DB, bool) {
+	db, found, _ := c.GetWith(key, GetOptions{Touch: true})
+
+	return db, found
+}
+
+// Peek - getting *sqlx.DB value by key without extending item expiration
+func (c *SafeDbMapCache) Peek(key string) (*sqlx.DB, bool) {
+	db, found, _ := c.GetWith(key, GetOptions{})
+
+	return db, found
+}
+
+// GetWithExpiration - getting *sqlx.DB value by key (extends item idle deadline) and its
+// expiration time - nearer of idle and absolute deadlines (zero time - never expires)
+func (c *SafeDbMapCache) GetWithExpiration(key string) (*sqlx.DB, time.Time, bool) {
+	key = c.hashKey(key)
+
+	db, res := c.read(key, true)
+
+	found := res == GetHit
+	if found && c.guardClosed(key, db) {
+		db, found = nil, false
+	}
+
+	c.getHook(key, found)
+
+	if !found {
+		return nil, time.Time{}, false
+	}
+
+	c.RLock()
+	item, found := c.pool.get(key)
+	c.RUnlock()
+
+	if !found || !item.owns(db) {
+		return nil, time.Time{}, false
+	}
+
+	var expiration time.Time
+	if deadline := item.deadline(); deadline > 0 {
+		expiration = time.Unix(0, deadline)
+	}
+
+	return db, expiration, true
+}
+
+// GetAlive - getting *sqlx.DB value by key and checking it with ping.
+// Dead connection is closed and removed from cache, its expiration isn't extended.
+// Alive connection item expiration is extended after successful ping unless opts
+// with Touch == false is passed (liveness probe keeping absolute expiration), opts.PingCtx is ignored.
+func (c *SafeDbMapCache) GetAlive(ctx context.Context, key string, opts ...GetOptions) (*sqlx.DB, bool, error) {
+	touch := true
+	if len(opts) > 0 {
+		touch = opts[0].Touch
+	}
+
+	return c.GetWith(key, GetOptions{Touch: touch, PingCtx: ctx})
+}
+
+// Any - returns key and connection of live item matching pred (nil - any item) round-robin
+// over sorted keys, doesn't extend item expiration and doesn't count as key read (hits, hooks).
+// Predicate gets item copy and is called under cache read lock, so it must not call cache methods.
+func (c *SafeDbMapCache) Any(pred func(item PoolItem) bool) (key string, db *sqlx.DB, ok bool) {
+	c.RLock()
+
+	now := c.now().UnixNano()
+
+	keys := make([]string, 0, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		if i.suspect() {
+			return
+		}
+
+		if deadline := i.deadline(); deadline > 0 && now > deadline {
+			return
+		}
+
+		if pred != nil && !pred(i.synced()) {
+			return
+		}
+
+		keys = append(keys, k)
+	})
+
+	if len(keys) == 0 {
+		c.RUnlock()
+
+		return "", nil, false
+	}
+
+	sort.Strings(keys)
+
+	key = keys[int((atomic.AddUint32(&c.anyNext, 1)-1)%uint32(len(keys)))]
+	db = c.pool.item(key).pick()
+
+	c.RUnlock()
+
+	return c.displayKeys([]string{key})[0], db, true
+}
+
+// read - getting not expired item Db, optionally extending its expiration
+// (atomically, under shard read lock - see itemAccess)
+func (c *SafeDbMapCache) read(key string, touch bool) (*sqlx.DB, GetResult) {
+	return c.readWith(key, touch, PoolItem.pick)
+}
+
+// readWith - getting connection of not expired item chosen by choose (see read).
+// Only shard of key is read locked, so reads of unrelated keys don't contend (see WithShards).
+func (c *SafeDbMapCache) readWith(key string, touch bool, choose func(PoolItem) *sqlx.DB) (*sqlx.DB, GetResult) {
+	s := c.pool.shard(key)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	item, found := s.items[key]
+
+	// cache not found or hidden until health check retry (see HealthRetry.Strict)
+	if !found || item.suspect() {
+		return nil, GetMissing
+	}
+
+	now := c.now()
+
+	if deadline := item.deadline(); deadline > 0 {
+
+		// cache expired
+		if now.UnixNano() > deadline {
+			return nil, GetExpired
+		}
+	}
+
+	if touch {
+		item.touch(now)
+	}
+
+	return choose(item), GetHit
+}
+
+// touchIfSame - extends expiration of not expired key item if it still holds db
+func (c *SafeDbMapCache) touchIfSame(key string, db *sqlx.DB) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(key)
+	if !found || !item.owns(db) {
+		return
+	}
+
+	now := c.now()
+
+	if deadline := item.deadline(); deadline > 0 && now.UnixNano() > deadline {
+		return
+	}
+
+	item.touch(now)
+}
+
+// evictIfSame - closes and removes item by key if it still holds db, returns true if removed.
+// Group member (see SetGroup) is dropped from its group while other members are left.
+func (c *SafeDbMapCache) evictIfSame(key string, db *sqlx.DB, reason EvictReason) bool {
+	c.Lock()
+
+	item, found := c.pool.get(key)
+	if !found || !item.owns(db) {
+		c.Unlock()
+		return false
+	}
+
+	if item.group != nil && !item.group.roles {
+		c.dropMember(key, item, db)
+
+		c.Unlock()
+
+		c.closeMember(key, db, reason)
+
+		return true
+	}
+
+	c.deleteItem(key)
+
+	c.Unlock()
+
+	c.closeRemoved([]removedItem{{key: key, item: item, reason: reason}})
+
+	return true
+}
+
+// Delete - delete *sqlx.DB value by key.
+// Returns ErrKeyNotFound if key not found, ErrClosed on closed cache and close error wrapped
+// with (redacted) key if connection close failed (item is removed anyway).
+func (c *SafeDbMapCache) Delete(key string) error {
+	_, err := c.tryDelete(context.Background(), key)
+
+	return err
 }
 
-type SafeDbMapCache struct {
-	sync.RWMutex
+// DeleteContext - Delete bounded by ctx: if connection close doesn't finish before ctx is done,
+// close error wrapping ctx error is returned and close goes on in background (item is removed anyway)
+func (c *SafeDbMapCache) DeleteContext(ctx context.Context, key string) error {
+	_, err := c.tryDelete(ctx, key)
 
-	pool              map[string]PoolItem
-	defaultExpiration time.Duration
-	cleanupInterval   time.Duration
+	return err
 }
 
-// New - initializing a new SafeDbMapCache cache
-func New(defaultExpiration, cleanupInterval time.Duration) *SafeDbMapCache {
-	items := make(map[string]PoolItem)
+// DeleteResult - TryDelete result
+type DeleteResult int
 
-	// cache item
-	cache := SafeDbMapCache{
-		pool:              items,
-		defaultExpiration: defaultExpiration,
-		cleanupInterval:   cleanupInterval,
-	}
+const (
+	// DeleteMissing - key is not found (or cache is closed), nothing is removed
+	DeleteMissing DeleteResult = iota
 
-	if cleanupInterval > 0 {
-		cache.StartGC()
+	// DeletedLive - not expired item is removed
+	DeletedLive
+
+	// DeletedExpired - expired item waiting for GC is removed
+	DeletedExpired
+)
+
+// String - returns result name
+func (r DeleteResult) String() string {
+	switch r {
+	case DeleteMissing:
+		return "missing"
+	case DeletedLive:
+		return "live"
+	case DeletedExpired:
+		return "expired"
+	default:
+		return "unknown"
 	}
+}
 
-	return &cache
+// TryDelete - delete *sqlx.DB value by key like Delete, result tells if removed item was live
+// or already expired (see GetExpired), e.g. for accounting of really closed connections.
+// Error is returned as by Delete, result of removed item is returned with its close error too.
+func (c *SafeDbMapCache) TryDelete(key string) (DeleteResult, error) {
+	return c.tryDelete(context.Background(), key)
 }
 
-// Set - setting *sqlx.DB value by key
-func (c *SafeDbMapCache) Set(key string, value *sqlx.DB, duration time.Duration) {
-	var expiration int64
+// tryDelete - removes item by key closing its connection within ctx (see closeItemContext)
+func (c *SafeDbMapCache) tryDelete(ctx context.Context, key string) (DeleteResult, error) {
+	if c.isClosed() {
+		return DeleteMissing, ErrClosed
+	}
 
-	if duration == 0 {
-		duration = c.defaultExpiration
+	key = c.hashKey(key)
+
+	c.Lock()
+
+	connector, found := c.pool.get(key)
+
+	if !found {
+		c.Unlock()
+		return DeleteMissing, ErrKeyNotFound
 	}
 
-	if duration > 0 {
-		expiration = time.Now().Add(duration).UnixNano()
+	res := DeletedLive
+	if deadline := connector.deadline(); deadline > 0 && c.now().UnixNano() > deadline {
+		res = DeletedExpired
 	}
 
-	c.Lock()
+	c.deleteItem(key)
 
-	defer c.Unlock()
+	c.Unlock()
+
+	c.forgetDialBucket(key)
 
-	c.pool[key] = PoolItem{
-		Db:         value,
-		Expiration: expiration,
-		Duration:   duration,
-		Created:    time.Now(),
+	err := c.closeItemContext(ctx, removedItem{key: key, item: connector, reason: ReasonDeleted})
+
+	if c.hooks != nil && c.hooks.OnDelete != nil {
+		c.runHook("OnDelete", func() { c.hooks.OnDelete(key, err) })
 	}
+
+	return res, err
 }
 
-// Get - getting *sqlx.DB value by key
-func (c *SafeDbMapCache) Get(key string) (*sqlx.DB, bool) {
-	c.RLock()
-	defer c.RUnlock()
+// DeleteMany - delete *sqlx.DB values by keys, found keys are removed even if some are missing.
+// Returns the same errors as Delete: first missing key (wrapped ErrKeyNotFound), otherwise
+// first close error, or ErrClosed on closed cache.
+func (c *SafeDbMapCache) DeleteMany(keys ...string) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
 
-	item, found := c.pool[key]
+	var firstErr error
+	var missing []string
 
-	// cache not found
-	if !found {
-		return nil, false
+	c.Lock()
+
+	removed := make([]removedItem, 0, len(keys))
+	for _, k := range keys {
+		hk := c.hashKey(k)
+
+		item, found := c.pool.get(hk)
+		if !found {
+			missing = append(missing, k)
+
+			continue
+		}
+
+		removed = append(removed, removedItem{key: hk, item: item, reason: ReasonDeleted})
+
+		c.deleteItem(hk)
 	}
 
-	if item.Expiration > 0 {
+	c.Unlock()
 
-		// cache expired
-		if time.Now().UnixNano() > item.Expiration {
-			return nil, false
+	if len(missing) != 0 {
+		firstErr = fmt.Errorf("%w: %s", ErrKeyNotFound, c.redact(missing[0]))
+	}
+
+	for _, r := range removed {
+		err := c.closeItem(r)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		if c.hooks != nil && c.hooks.OnDelete != nil {
+			c.runHook("OnDelete", func() { c.hooks.OnDelete(r.key, err) })
 		}
 	}
 
-	////TODO: set new timeout (?????? - think about it)
-	var newExpiration int64
-	if item.Duration > 0 {
-		newExpiration = time.Now().Add(item.Duration).UnixNano()
+	return firstErr
+}
+
+// closeRemoved - closes connections of removed items and calls eviction callback.
+// Must be called without lock.
+func (c *SafeDbMapCache) closeRemoved(removed []removedItem) {
+	for _, r := range removed {
+		_ = c.closeItem(r)
 	}
+}
 
-	c.pool[key] = PoolItem{
-		Db:         item.Db,
-		Expiration: newExpiration,
-		Duration:   item.Duration,
-		Created:    time.Now(),
+// closeItemContext - closeItem bounded by ctx: if close doesn't finish before ctx is done,
+// close error wrapping ctx error is returned and close goes on in background.
+// Must be called without lock.
+func (c *SafeDbMapCache) closeItemContext(ctx context.Context, r removedItem) error {
+	// never done context - no need for close goroutine
+	if ctx.Done() == nil {
+		return c.closeItem(r)
 	}
 
-	return item.Db, true
+	done := make(chan error, 1)
+
+	c.closing.Add(1)
+	go func() {
+		defer c.closing.Done()
+
+		done <- c.closeItem(r)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("dbpool: close %q: %w", c.redact(r.key), ctx.Err())
+	}
 }
 
-// Delete - delete *sqlx.DB value by key
-// Return false if key not found
-func (c *SafeDbMapCache) Delete(key string) error {
+// closeDb - closes connection of key with its item close function (see SetOptions.CloseFunc)
+// or pool one (see WithCloseFunc) after before-close hook (see WithBeforeClose). Must be called without lock.
+func (c *SafeDbMapCache) closeDb(key string, db *sqlx.DB) error {
+	if c.beforeClose != nil {
+		c.runHook("BeforeClose", func() { c.beforeClose(key, db) })
+	}
+
+	c.dropStmts(db)
+
 	c.Lock()
-	defer c.Unlock()
+	closeFunc, found := c.closers[db]
+	delete(c.closers, db)
+	c.Unlock()
+
+	if found {
+		return closeFunc(db)
+	}
 
-	connector, found := c.pool[key]
+	if c.closeFunc != nil {
+		return c.closeFunc(db)
+	}
 
-	if !found {
-		return errors.New("key not found")
+	return db.Close()
+}
+
+// closeItem - closes connection of removed item and calls eviction callback.
+// Must be called without lock.
+func (c *SafeDbMapCache) closeItem(r removedItem) error {
+	var err error
+
+	// connection stored under other keys too is closed with the last of them
+	if !c.shared(r.item.Db) {
+		err = c.closeDb(r.key, r.item.Db)
+	}
+
+	if mErr := c.closeMembers(r.key, r.item.groupMembers()); err == nil {
+		err = mErr
 	}
 
-	err := connector.Db.Close()
 	if err != nil {
-		Logger.Warningf("db connection close error: %s", err.Error())
+		key := c.redact(r.key)
+
+		Logger.Warningf("db connection of key %s close error: %s", key, err.Error())
+
+		err = fmt.Errorf("dbpool: close %q: %w", key, err)
 	}
 
-	delete(c.pool, key)
+	if c.onEvict != nil {
+		item := r.item
+		item.Metadata = copyMetadata(item.Metadata)
 
-	return nil
+		c.onEvict(r.key, item.synced(), r.reason)
+	}
+
+	c.recordRemoval(r.key, r.reason)
+
+	if n, ok := c.policy.(removeNotifier); ok {
+		n.OnRemove(r.key)
+	}
+
+	c.emitEvent(Event{
+		Key:    c.redact(r.key),
+		Reason: r.reason,
+		Age:    c.now().Sub(r.item.FirstCreated),
+		Err:    err,
+	})
+
+	c.forgetDisplay(r.key)
+
+	if c.onRemoved != nil {
+		c.onRemoved(r.key)
+	}
+
+	return err
 }
 
-// StartGC - start Garbage Collection
-func (c *SafeDbMapCache) StartGC() {
+// StartGC - start Garbage Collection (New starts it for positive cleanup interval).
+// Returns false without starting the second GC loop if GC is already running.
+func (c *SafeDbMapCache) StartGC() bool {
+	if !atomic.CompareAndSwapInt32(&c.gcRunning, 0, 1) {
+		return false
+	}
+
 	go c.GC()
+
+	return true
 }
 
 // GC - Garbage Collection cycle
 func (c *SafeDbMapCache) GC() {
 	for {
-		<-time.After(c.cleanupInterval)
+		interval := time.Duration(atomic.LoadInt64(&c.gcInterval))
+
+		// GC disabled by SetCleanupInterval
+		if interval <= 0 {
+			atomic.StoreInt32(&c.gcRunning, 0)
+
+			// interval could be changed before running flag was dropped
+			if atomic.LoadInt64(&c.gcInterval) > 0 && atomic.CompareAndSwapInt32(&c.gcRunning, 0, 1) {
+				continue
+			}
+
+			return
+		}
+
+		select {
+		case <-c.stop:
+			atomic.StoreInt32(&c.gcRunning, 0)
+			return
+		case <-c.gcReset:
+			continue
+		case <-c.gcResume:
+		case <-time.After(interval):
+		}
 
 		if c.pool == nil {
 			return
 		}
 
-		if keys := c.ExpiredKeys(); len(keys) != 0 {
-			c.clearItems(keys)
+		// paused - expired items are kept until ResumeGC
+		if atomic.LoadInt32(&c.gcPaused) == 1 {
+			continue
+		}
+
+		c.safeGCCycle()
+	}
+}
+
+// safeGCCycle - GC sweep which panic doesn't stop GC loop: it is logged with stack trace
+// and counted (see Stats.PanicCount), re-raised if WithGCRepanic is set
+func (c *SafeDbMapCache) safeGCCycle() {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		atomic.AddInt64(&c.gcPanics, 1)
+
+		Logger.Errorf("db pool gc panic: %v\n%s", r, debug.Stack())
+
+		if c.gcRepanic {
+			panic(r)
+		}
+	}()
+
+	c.gcCycle()
+}
+
+// PauseGC - pause automatic Garbage Collection (GC goroutine keeps running).
+// Expired items are kept in cache until ResumeGC, DeleteExpired still works.
+func (c *SafeDbMapCache) PauseGC() {
+	atomic.StoreInt32(&c.gcPaused, 1)
+}
+
+// ResumeGC - resume automatic Garbage Collection paused by PauseGC.
+// Items expired during pause are swept by running GC immediately.
+func (c *SafeDbMapCache) ResumeGC() {
+	if !atomic.CompareAndSwapInt32(&c.gcPaused, 1, 0) {
+		return
+	}
+
+	select {
+	case c.gcResume <- struct{}{}:
+	default:
+	}
+}
+
+// GCPaused - returns true if automatic Garbage Collection is paused
+func (c *SafeDbMapCache) GCPaused() bool {
+	return atomic.LoadInt32(&c.gcPaused) == 1
+}
+
+// DeleteExpired - removes all expired items, returns number of removed items
+func (c *SafeDbMapCache) DeleteExpired() int {
+	var removed int
+
+	keys := c.expiredKeys()
+	if len(keys) != 0 {
+		removed = c.clearItems(keys)
+	}
+
+	return removed
+}
+
+// RunGCOnce - performs single GC sweep synchronously (expired items eviction, close guard and
+// health checks if enabled, OnGCRun hook), returns number of evicted items. It works with GC
+// stopped or disabled (zero cleanup interval), e.g. driven by caller scheduler.
+func (c *SafeDbMapCache) RunGCOnce() (evicted int) {
+	if c.isClosed() {
+		return 0
+	}
+
+	return c.gcCycle()
+}
+
+// isClosed - returns true if cache is closed by Shutdown
+func (c *SafeDbMapCache) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Shutdown - stops Garbage Collection and removes all items.
+// Set-like methods on closed cache don't store connections (TrySet returns ErrClosed),
+// Get-like methods find nothing and Delete returns ErrClosed. Set racing with Shutdown
+// either fails or stores connection closed by Shutdown.
+func (c *SafeDbMapCache) Shutdown() {
+	_ = c.CloseContext(context.Background())
+}
+
+// CloseContext - Shutdown bounded by ctx, so stuck connection close doesn't hang it: if closes
+// don't finish before ctx is done, the rest of them go on in background. Cache is closed anyway,
+// events channel (see Events) is closed once background closes emit their removal events.
+// Returns *CloseError describing failed (or interrupted by ctx) closes of items.
+func (c *SafeDbMapCache) CloseContext(ctx context.Context) error {
+	atomic.StoreInt32(&c.closed, 1)
+
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+
+	err := c.clearAll(ctx)
+
+	finish := func() {
+		c.closeLeased()
+		c.drainPending()
+
+		// removal events of items closed in background are emitted before events channel close
+		c.closing.Wait()
+		c.closeEvents()
+	}
+
+	if ctx.Err() != nil {
+		go finish()
+
+		return err
+	}
+
+	finish()
+
+	return err
+}
+
+// gcCycle - single GC sweep, returns number of evicted items
+func (c *SafeDbMapCache) gcCycle() int {
+	// sweep duration is measured by real clock (not by WithClock one)
+	start := time.Now()
+
+	c.RLock()
+	size := c.pool.len()
+	c.RUnlock()
+
+	var evictedKeys []string
+
+	keys := c.expiredKeys()
+	if len(keys) != 0 {
+		removed := c.removeItems(keys)
+		c.closeEvicted(removed)
+
+		for _, r := range removed {
+			evictedKeys = append(evictedKeys, r.key)
 		}
 	}
+
+	if c.closeGuard {
+		evictedKeys = append(evictedKeys, c.guardSweep()...)
+	}
+
+	if c.healthCheckOnGC {
+		evictedKeys = append(evictedKeys, c.gcHealthCheck()...)
+	}
+
+	evicted := len(evictedKeys)
+
+	took := time.Since(start)
+	atomic.StoreInt64(&c.lastGCDuration, int64(took))
+	atomic.StoreInt64(&c.lastGCEvicted, int64(evicted))
+
+	interval := time.Duration(atomic.LoadInt64(&c.gcInterval))
+	if interval > 0 && took > interval {
+		Logger.Warningf("db pool gc sweep took %s, longer than gc interval %s", took, interval)
+	}
+
+	if c.adaptiveGC {
+		c.adaptInterval(evicted, size)
+	}
+
+	if c.hooks != nil && c.hooks.OnGCRun != nil {
+		c.runHook("OnGCRun", func() { c.hooks.OnGCRun(evictedKeys, took) })
+	}
+
+	return evicted
+}
+
+// adaptiveLargePool - pool size starting from which pool is considered large by adaptive GC
+const adaptiveLargePool = 1000
+
+// adaptInterval - recalculates effective GC interval using previous cycle results
+func (c *SafeDbMapCache) adaptInterval(evicted, size int) {
+	interval := time.Duration(atomic.LoadInt64(&c.gcInterval))
+
+	switch {
+	case evicted == 0:
+		// nothing is expiring - GC can relax
+		interval *= 2
+	case evicted*4 >= size || size >= adaptiveLargePool:
+		// many items evicted (or large pool) - GC should hurry up
+		interval /= 2
+	}
+
+	atomic.StoreInt64(&c.gcInterval, int64(c.clampInterval(interval)))
+}
+
+// clampInterval - clamps GC interval to adaptive GC bounds (if enabled)
+func (c *SafeDbMapCache) clampInterval(interval time.Duration) time.Duration {
+	if !c.adaptiveGC {
+		return interval
+	}
+
+	if interval < c.minCleanupInterval {
+		return c.minCleanupInterval
+	}
+
+	if interval > c.maxCleanupInterval {
+		return c.maxCleanupInterval
+	}
+
+	return interval
 }
 
 // GetItems - returns item list.
 func (c *SafeDbMapCache) GetItems() (items []string) {
 	c.RLock()
-	defer c.RUnlock()
 
-	for k, _ := range c.pool {
+	c.pool.each(func(k string, _ PoolItem) {
 		items = append(items, k)
-	}
+	})
 
-	return
+	c.RUnlock()
+
+	return c.displayKeys(items)
 }
 
-// ExpiredKeys - returns list of expired keys.
-func (c *SafeDbMapCache) ExpiredKeys() (keys []string) {
+// ExpiredKeys - returns list of expired keys (idle or absolute deadline passed, see also WithEvictionPolicy).
+// In serve-stale mode items are considered expired after hard expiry only.
+// Items older than max lifetime (see WithMaxLifetime) are expired regardless of TTL.
+func (c *SafeDbMapCache) ExpiredKeys() []string {
+	return c.displayKeys(c.expiredKeys())
+}
+
+// expiredKeys - returns list of expired internal keys (see WithHashedKeys)
+func (c *SafeDbMapCache) expiredKeys() (keys []string) {
 	c.RLock()
 	defer c.RUnlock()
 
-	for k, i := range c.pool {
-		if time.Now().UnixNano() > i.Expiration && i.Expiration > 0 {
+	c.pool.each(func(k string, i PoolItem) {
+		if c.expired(k, i) {
 			keys = append(keys, k)
 		}
-	}
+	})
 
 	return
 }
 
-// clearItems - removes all the items with key in keys.
-func (c *SafeDbMapCache) clearItems(keys []string) {
+// Keys - returns live and expired (see ExpiredKeys) keys observed in one pass
+func (c *SafeDbMapCache) Keys() (live []string, expired []string) {
+	c.RLock()
+
+	c.pool.each(func(k string, i PoolItem) {
+		if c.expired(k, i) {
+			expired = append(expired, k)
+		} else {
+			live = append(live, k)
+		}
+	})
+
+	c.RUnlock()
+
+	return c.displayKeys(live), c.displayKeys(expired)
+}
+
+// expired - returns true if item should be collected by GC: TTL is expired,
+// item is older than max age or eviction policy says so
+func (c *SafeDbMapCache) expired(key string, item PoolItem) bool {
+	return c.ttlExpired(item) || c.outlived(item) || c.policyEvicts(key, item)
+}
+
+// ttlExpired - returns true if item TTL (with serve-stale hard expiry) is expired
+func (c *SafeDbMapCache) ttlExpired(item PoolItem) bool {
+	deadline := item.deadline()
+
+	return deadline > 0 && c.now().UnixNano() > deadline+int64(c.staleTTL)
+}
+
+// outlived - returns true if item is older than its max age
+// (SetOptions.MaxAge or pool max lifetime, see WithMaxLifetime)
+func (c *SafeDbMapCache) outlived(item PoolItem) bool {
+	maxAge := c.maxLifetime
+	if item.maxAge != 0 {
+		maxAge = item.maxAge
+	}
+
+	return maxAge > 0 && c.now().Sub(item.FirstCreated) > maxAge
+}
+
+// clearItems - removes all the items with key in keys, returns number of removed items.
+// Busy connections are skipped in evict-only-idle mode (see WithEvictOnlyIdle).
+func (c *SafeDbMapCache) clearItems(keys []string) int {
+	removed := c.removeItems(keys)
+
+	c.closeRemoved(removed)
+
+	return len(removed)
+}
+
+// removeItems - removes all the items with key in keys from pool without closing.
+// Busy connections are skipped in evict-only-idle mode (see WithEvictOnlyIdle),
+// warm ones are renewed instead of TTL expiration (see WithMinEntries, SetOptions.Pinned).
+// Outlived items with connect func are rotated in background instead of removal.
+func (c *SafeDbMapCache) removeItems(keys []string) []removedItem {
+	var rotate []pingTarget
+
 	c.Lock()
-	defer c.Unlock()
+
+	removed := make([]removedItem, 0, len(keys))
+
+	// eviction policy or connection stats panic (see safeGCCycle) must not leave cache locked,
+	// items removed before it are closed anyway
+	locked := true
+	defer func() {
+		if locked {
+			c.Unlock()
+			c.closeRemoved(removed)
+		}
+	}()
+
+	warm := c.warmKeys()
 
 	for _, k := range keys {
-		connector, ok := c.pool[k]
+		connector, ok := c.pool.get(k)
 
-		if ok {
-			err := connector.Db.Close()
-			if err != nil {
-				Logger.Warningf("db connection close error: %s", err.Error())
+		if !ok {
+			continue
+		}
+
+		if c.deferEviction(k, connector) {
+			continue
+		}
+
+		reason := ReasonExpired
+		switch {
+		case c.ttlExpired(connector):
+			if _, found := warm[k]; found {
+				c.keepWarm(k, connector)
+				continue
 			}
+		case c.outlived(connector):
+			if connector.connect != nil {
+				rotate = append(rotate, pingTarget{key: k, db: connector.Db})
+				continue
+			}
+
+			reason = ReasonMaxAge
+		case c.policyEvicts(k, connector):
+			reason = ReasonPolicy
+		default:
+			// item was refreshed meanwhile
+			continue
 		}
 
-		delete(c.pool, k)
+		removed = append(removed, removedItem{key: k, item: connector, reason: reason})
+
+		c.deleteItem(k)
+	}
+
+	locked = false
+	c.Unlock()
+
+	for _, t := range rotate {
+		go c.rotate(t.key, t.db)
 	}
+
+	return removed
+}
+
+// deferEviction - returns true if item connection is in use and its eviction should be deferred.
+// Must be called under write lock.
+func (c *SafeDbMapCache) deferEviction(key string, item PoolItem) bool {
+	if !c.evictOnlyIdle || item.Db.Stats().InUse == 0 {
+		return false
+	}
+
+	// deferred too many times - force close
+	if c.maxEvictDeferrals > 0 && item.evictDeferrals >= c.maxEvictDeferrals {
+		return false
+	}
+
+	item.evictDeferrals++
+	c.pool.set(key, item)
+
+	atomic.AddInt64(&c.deferredEvictions, 1)
+
+	return true
 }
 
 // ClearAll - removes all items.
 func (c *SafeDbMapCache) ClearAll() {
+	_ = c.ClearAllErr()
+}
+
+// ClearAllErr - removes all items closing their connections in sorted key order.
+// Every connection is closed even if some closes fail, returns *CloseError describing all failed keys.
+// No-op on closed cache (Shutdown has already removed everything).
+func (c *SafeDbMapCache) ClearAllErr() error {
+	if c.isClosed() {
+		return nil
+	}
+
+	return c.clearAll(context.Background())
+}
+
+// ClearAllContext - ClearAllErr bounded by ctx: if closes don't finish before ctx is done,
+// ctx error wrapped with interrupted key is reported, closes of the rest of items go on in background
+// (all items are removed anyway)
+func (c *SafeDbMapCache) ClearAllContext(ctx context.Context) error {
+	if c.isClosed() {
+		return nil
+	}
+
+	return c.clearAll(ctx)
+}
+
+// clearAll - removes all items closing their connections in sorted key order within ctx
+func (c *SafeDbMapCache) clearAll(ctx context.Context) error {
 	c.Lock()
-	defer c.Unlock()
 
-	for k := range c.pool {
-		connector, ok := c.pool[k]
+	removed := make([]removedItem, 0, c.pool.len())
+	c.pool.each(func(k string, _ PoolItem) {
+		connector, ok := c.pool.get(k)
 
 		if ok {
-			err := connector.Db.Close()
-			if err != nil {
-				Logger.Warningf("db connection close error: %s", err.Error())
-			}
+			removed = append(removed, removedItem{key: k, item: connector, reason: ReasonCleared})
+		}
+
+		c.deleteItem(k)
+	})
+
+	c.Unlock()
+
+	// stable order for reproducible logs
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].key < removed[j].key
+	})
+
+	errs := make(map[string]error)
+	for n, r := range removed {
+		if err := c.closeItemContext(ctx, r); err != nil {
+			errs[r.key] = err
+		}
+
+		// deadline is passed - the rest is closed in background
+		if ctx.Err() != nil {
+			c.closing.Add(1)
+			go func(rest []removedItem) {
+				defer c.closing.Done()
+
+				c.closeRemoved(rest)
+			}(removed[n+1:])
+
+			break
 		}
+	}
 
-		delete(c.pool, k)
+	if len(errs) == 0 {
+		return nil
 	}
+
+	return &CloseError{Errors: errs}
 }
@@ -0,0 +1,133 @@
+package dbpool
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+/////// Debug http handler ///////////
+
+// DebugItem - pool item state served by DebugHandler
+type DebugItem struct {
+	Key          string        `json:"key"`
+	Created      time.Time     `json:"created"`
+	LastAccessed time.Time     `json:"last_accessed"`
+	TTLRemaining time.Duration `json:"ttl_remaining"` // 0 - never expires
+
+	DBStats sql.DBStats `json:"db_stats"`
+}
+
+// DebugState - pool state served by DebugHandler
+type DebugState struct {
+	Stats Stats       `json:"stats"`
+	Items []DebugItem `json:"items"`
+}
+
+// DebugHandler - returns read-only http handler serving pool state as json
+// (or as text table with ?format=text). ?key=... serves one item (404 if not found).
+// Keys are redacted with WithKeyRedactor function, item TTLs are not extended.
+func (c *SafeDbMapCache) DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		key, single := query["key"]
+
+		var keys []string
+		if single {
+			keys = key[:1]
+		}
+
+		state := c.debugState(keys)
+		if single && len(state.Items) == 0 {
+			http.Error(w, ErrKeyNotFound.Error(), http.StatusNotFound)
+			return
+		}
+
+		if query.Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeDebugText(w, state)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(state)
+	})
+}
+
+// debugState - returns snapshot of items by keys (all items if keys are empty) sorted by key
+func (c *SafeDbMapCache) debugState(keys []string) DebugState {
+	state := DebugState{Stats: c.Stats()}
+
+	c.RLock()
+
+	now := c.now().UnixNano()
+
+	add := func(k string, i PoolItem) {
+		var ttl time.Duration
+		if deadline := i.deadline(); deadline > 0 {
+			ttl = time.Duration(deadline - now)
+		}
+
+		state.Items = append(state.Items, DebugItem{
+			Key:          k,
+			Created:      i.FirstCreated,
+			LastAccessed: i.lastAccess(),
+			TTLRemaining: ttl,
+			DBStats:      i.Db.Stats(),
+		})
+	}
+
+	if len(keys) == 0 {
+		c.pool.each(func(k string, i PoolItem) {
+			add(k, i)
+		})
+	}
+
+	for _, k := range keys {
+		k = c.hashKey(k)
+		if i, found := c.pool.get(k); found {
+			add(k, i)
+		}
+	}
+
+	c.RUnlock()
+
+	// keys are redacted without cache lock
+	for i := range state.Items {
+		state.Items[i].Key = c.redact(state.Items[i].Key)
+	}
+
+	sort.Slice(state.Items, func(i, j int) bool {
+		return state.Items[i].Key < state.Items[j].Key
+	})
+
+	return state
+}
+
+// writeDebugText - writes pool state as human-readable table
+func writeDebugText(w http.ResponseWriter, state DebugState) {
+	_, _ = fmt.Fprintf(w, "items: %d, hits: %d, misses: %d\n\n", state.Stats.Items, state.Stats.Hits, state.Stats.Misses)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	_, _ = fmt.Fprintln(tw, "KEY\tCREATED\tLAST ACCESSED\tTTL\tOPEN\tIN USE\tIDLE")
+	for _, i := range state.Items {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n",
+			i.Key,
+			i.Created.Format(time.RFC3339),
+			i.LastAccessed.Format(time.RFC3339),
+			i.TTLRemaining,
+			i.DBStats.OpenConnections,
+			i.DBStats.InUse,
+			i.DBStats.Idle,
+		)
+	}
+
+	_ = tw.Flush()
+}
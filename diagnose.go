@@ -0,0 +1,143 @@
+package dbpool
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/////// Configuration diagnostics ///////////
+
+const (
+	// diagnoseIdleAge - never expiring item not accessed for longer is reported
+	diagnoseIdleAge = 72 * time.Hour
+
+	// diagnoseSlidingAge - item kept alive by sliding TTL for longer is reported
+	diagnoseSlidingAge = 7 * 24 * time.Hour
+
+	// diagnoseManyPermanent - number of never expiring items reported as too many
+	diagnoseManyPermanent = 100
+)
+
+// Severity - Finding severity
+type Severity int
+
+const (
+	// SeverityInfo - suspicious, but may be intended
+	SeverityInfo Severity = iota
+
+	// SeverityWarning - likely misconfiguration
+	SeverityWarning
+)
+
+// String - returns severity name
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Finding - possible misconfiguration found by Diagnose
+type Finding struct {
+	Severity Severity
+	Key      string // redacted key of item (see WithKeyRedactor), empty for pool-wide finding
+	Message  string
+}
+
+// diagnosedItem - item state inspected by Diagnose
+type diagnosedItem struct {
+	key       string
+	permanent bool
+	idle      time.Duration
+	sliding   time.Duration // age of item kept alive by sliding TTL only (0 - not such item)
+	ttl       time.Duration
+}
+
+// Diagnose - inspects pool configuration and current items, returns possible misconfigurations:
+// disabled GC with expiring items, default expiration shorter than GC interval, too many never
+// expiring items, never expiring items not accessed for long and items kept alive by sliding TTL
+// for long without max lifetime (see WithMaxLifetime, SetOptions.MaxTTL). Pool-wide findings come first.
+func (c *SafeDbMapCache) Diagnose() []Finding {
+	interval := time.Duration(atomic.LoadInt64(&c.gcInterval))
+	paused := atomic.LoadInt32(&c.gcPaused) == 1
+
+	c.RLock()
+
+	now := c.now()
+	defaultExpiration := c.defaultExpiration
+
+	var expiring, permanent int
+
+	var items []diagnosedItem
+	c.pool.each(func(k string, i PoolItem) {
+		d := diagnosedItem{key: k, ttl: i.Duration}
+
+		if i.deadline() > 0 {
+			expiring++
+		} else {
+			permanent++
+			d.permanent = true
+			d.idle = now.Sub(i.lastAccess())
+		}
+
+		if i.Duration > 0 && i.MaxExpiration == 0 && i.maxAge == 0 && c.maxLifetime == 0 {
+			d.sliding = now.Sub(i.FirstCreated)
+		}
+
+		if (d.permanent && d.idle > diagnoseIdleAge) || d.sliding > diagnoseSlidingAge {
+			items = append(items, d)
+		}
+	})
+
+	c.RUnlock()
+
+	var findings []Finding
+
+	switch {
+	case interval <= 0 && expiring > 0:
+		findings = append(findings, Finding{Severity: SeverityWarning,
+			Message: fmt.Sprintf("GC is disabled but %d item(s) have TTL, expired items are never closed", expiring)})
+	case paused && expiring > 0:
+		findings = append(findings, Finding{Severity: SeverityInfo,
+			Message: fmt.Sprintf("GC is paused, %d item(s) with TTL aren't closed on expiration", expiring)})
+	}
+
+	if interval > 0 && defaultExpiration > 0 && defaultExpiration < interval {
+		findings = append(findings, Finding{Severity: SeverityWarning,
+			Message: fmt.Sprintf("default expiration %s is shorter than GC interval %s, expired items are kept up to %s",
+				defaultExpiration, interval, interval)})
+	}
+
+	if permanent >= diagnoseManyPermanent {
+		findings = append(findings, Finding{Severity: SeverityWarning,
+			Message: fmt.Sprintf("%d items never expire", permanent)})
+	}
+
+	// keys are redacted without cache lock
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key < items[j].key
+	})
+
+	for _, d := range items {
+		key := c.redact(d.key)
+
+		if d.permanent && d.idle > diagnoseIdleAge {
+			findings = append(findings, Finding{Severity: SeverityWarning, Key: key,
+				Message: fmt.Sprintf("item %s never expires and hasn't been accessed for %s", key, d.idle.Round(time.Minute))})
+		}
+
+		if d.sliding > diagnoseSlidingAge {
+			findings = append(findings, Finding{Severity: SeverityInfo, Key: key,
+				Message: fmt.Sprintf("item %s is kept alive by sliding TTL %s for %s without max lifetime",
+					key, d.ttl, d.sliding.Round(time.Minute))})
+		}
+	}
+
+	return findings
+}
@@ -0,0 +1,42 @@
+package dbpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+/////// Pool-wide limit of concurrent dials ///////////
+
+// acquireDial - takes dial slot, waits for free one if all are taken (see WithMaxConcurrentDials).
+// Returns ctx.Err() if ctx is done before slot is free.
+func (c *SafeDbMapCache) acquireDial(ctx context.Context) error {
+	if c.dialSlots == nil {
+		return nil
+	}
+
+	// fast path, dial isn't queued
+	select {
+	case c.dialSlots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	atomic.AddInt64(&c.dialQueued, 1)
+	defer atomic.AddInt64(&c.dialQueued, -1)
+
+	select {
+	case c.dialSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseDial - frees dial slot taken by acquireDial
+func (c *SafeDbMapCache) releaseDial() {
+	if c.dialSlots == nil {
+		return
+	}
+
+	<-c.dialSlots
+}
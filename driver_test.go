@@ -0,0 +1,241 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Fake sql driver for tests (no network) ///////////
+
+const testDriverName = "dbpooltest"
+
+func init() {
+	sql.Register(testDriverName, testDriver{})
+}
+
+type testDriver struct{}
+
+// testPingErrors - ping errors by dsn (see failPing)
+var testPingErrors sync.Map
+
+// testCloseErrors - connection close errors by dsn (see failClose)
+var testCloseErrors sync.Map
+
+// testExecErrors - statement errors by dsn (see failExec)
+var testExecErrors sync.Map
+
+func (testDriver) Open(name string) (driver.Conn, error) {
+	return &testConn{dsn: name}, nil
+}
+
+type testConn struct {
+	dsn string
+}
+
+func (c *testConn) Ping(ctx context.Context) error {
+	if err, ok := testPingErrors.Load(c.dsn); ok {
+		return err.(error)
+	}
+
+	return nil
+}
+
+// testPrepares - total number of prepared fake statements
+var testPrepares int32
+
+func (c *testConn) Prepare(query string) (driver.Stmt, error) {
+	atomic.AddInt32(&testPrepares, 1)
+
+	return &testStmt{conn: c}, nil
+}
+
+type testStmt struct {
+	conn *testConn
+}
+
+func (*testStmt) Close() error {
+	return nil
+}
+
+func (*testStmt) NumInput() int {
+	return -1
+}
+
+func (s *testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), "", nil)
+}
+
+func (s *testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), "", nil)
+}
+
+func (c *testConn) Close() error {
+	if err, ok := testCloseErrors.Load(c.dsn); ok {
+		return err.(error)
+	}
+
+	return nil
+}
+
+func (*testConn) Begin() (driver.Tx, error) {
+	return testTx{}, nil
+}
+
+// testTxEnds - number of finished fake transactions by result ("commit", "rollback")
+var testTxEnds sync.Map
+
+type testTx struct{}
+
+func (testTx) Commit() error {
+	countTxEnd("commit")
+	return nil
+}
+
+func (testTx) Rollback() error {
+	countTxEnd("rollback")
+	return nil
+}
+
+func countTxEnd(result string) {
+	n, _ := testTxEnds.LoadOrStore(result, new(int32))
+	atomic.AddInt32(n.(*int32), 1)
+}
+
+// txEnds - returns number of finished fake transactions with result
+func txEnds(result string) int32 {
+	n, ok := testTxEnds.Load(result)
+	if !ok {
+		return 0
+	}
+
+	return atomic.LoadInt32(n.(*int32))
+}
+
+// ExecContext - every statement affects one row
+func (c *testConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err, ok := testExecErrors.Load(c.dsn); ok {
+		return nil, err.(error)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext - every query returns single row with column n = 1
+func (*testConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &testRows{}, nil
+}
+
+type testRows struct {
+	done bool
+}
+
+func (*testRows) Columns() []string {
+	return []string{"n"}
+}
+
+func (*testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+	dest[0] = int64(1)
+
+	return nil
+}
+
+// newFakeDb - returns *sqlx.DB working with fake driver
+func newFakeDb(t *testing.T) *sqlx.DB {
+	return newFakeDbDsn(t, t.Name())
+}
+
+// newFakeDbDsn - returns *sqlx.DB working with fake driver with given dsn
+func newFakeDbDsn(t *testing.T, dsn string) *sqlx.DB {
+	db, err := sqlx.Open(testDriverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// failPing - makes pings of fake connections with dsn fail (nil err - succeed)
+func failPing(dsn string, err error) {
+	if err == nil {
+		testPingErrors.Delete(dsn)
+		return
+	}
+
+	testPingErrors.Store(dsn, err)
+}
+
+// failClose - makes closes of fake connections with dsn fail (nil err - succeed)
+func failClose(dsn string, err error) {
+	if err == nil {
+		testCloseErrors.Delete(dsn)
+		return
+	}
+
+	testCloseErrors.Store(dsn, err)
+}
+
+// failExec - makes statements of fake connections with dsn fail (nil err - succeed)
+func failExec(dsn string, err error) {
+	if err == nil {
+		testExecErrors.Delete(dsn)
+		return
+	}
+
+	testExecErrors.Store(dsn, err)
+}
+
+// testClock - manually advanced clock for tests
+type testClock struct {
+	sync.Mutex
+
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Unix(1600000000, 0)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// waitFor - polls cond until it is true or okTimeout is passed
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(okTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition isn't met in time")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package dbpool
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/////// SafeDbMapCache errors ///////////
+
+// Delete-like methods (Delete, DeleteMany, DeleteNamespace) return error only:
+// nil - removed, ErrKeyNotFound (check with errors.Is) - nothing to remove,
+// ErrClosed - cache is closed, other - removed but connection close failed.
+
+var (
+	// ErrKeyNotFound - key is not found in cache
+	ErrKeyNotFound = errors.New("dbpool: key not found")
+
+	// ErrClosed - cache is closed (see Shutdown)
+	ErrClosed = errors.New("dbpool: cache is closed")
+
+	// ErrPoolClosed - alias of ErrClosed.
+	//
+	// Deprecated: use ErrClosed.
+	ErrPoolClosed = ErrClosed
+
+	// ErrBudgetExceeded - connection doesn't fit pool-wide connection budget (see WithConnBudget)
+	ErrBudgetExceeded = errors.New("dbpool: connection budget exceeded")
+
+	// ErrPoolFull - pool has max number of items (see WithMaxItems, Reject)
+	ErrPoolFull = errors.New("dbpool: pool is full")
+
+	// ErrCircuitOpen - circuit breaker of key is open (see WithCircuitBreaker)
+	ErrCircuitOpen = errors.New("dbpool: circuit breaker is open")
+
+	// ErrDialRateLimited - dial rate of key is exceeded (see WithDialRateLimit)
+	ErrDialRateLimited = errors.New("dbpool: dial rate limited")
+
+	// ErrNoFactory - item has no connect func to rebuild connection (see SetFactory)
+	ErrNoFactory = errors.New("dbpool: no connection factory")
+
+	// ErrEmptyGroup - connection group has no connections (see SetGroup)
+	ErrEmptyGroup = errors.New("dbpool: empty connection group")
+
+	// ErrNoReplica - item has no replica with given index (see SetRoles, UpdateWeight)
+	ErrNoReplica = errors.New("dbpool: replica not found")
+)
+
+// CloseError - connection close errors by internal key (see ClearAllErr, WithHashedKeys).
+// Keys aren't redacted, so keys with the same redacted form don't hide each other's errors.
+type CloseError struct {
+	Errors map[string]error
+}
+
+// Error - returns close errors of all failed keys sorted by message (each error names its redacted key)
+func (e *CloseError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	sort.Strings(msgs)
+
+	return fmt.Sprintf("dbpool: close failed for %d key(s): %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Is - returns true if close error of any key matches target (errors.Is support)
+func (e *CloseError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As - finds the first close error (in sorted key order) matching target (errors.As support)
+func (e *CloseError) As(target interface{}) bool {
+	keys := make([]string, 0, len(e.Errors))
+	for k := range e.Errors {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if errors.As(e.Errors[k], target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RecreateError - connect errors by internal key (see RecreateAll).
+// Keys aren't redacted like in CloseError, each error names its redacted key.
+type RecreateError struct {
+	Errors map[string]error
+}
+
+// Error - returns connect errors of all failed keys sorted by message
+func (e *RecreateError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	sort.Strings(msgs)
+
+	return fmt.Sprintf("dbpool: recreate failed for %d key(s): %s", len(msgs), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,60 @@
+package dbpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/////// Eviction events ///////////
+
+// defaultEventBuffer - default Events channel buffer size
+const defaultEventBuffer = 128
+
+// Event - item removal event (see Events)
+type Event struct {
+	Key    string // redacted key (see WithKeyRedactor)
+	Reason EvictReason
+
+	// Age - connection age since first creation (PoolItem.FirstCreated)
+	Age time.Duration
+
+	// Err - connection close error, if any
+	Err error
+}
+
+// Events - returns channel receiving exactly one event per removed item (any removal path).
+// Channel is buffered (see WithEventBuffer): when consumer is slow and buffer is full,
+// new events are dropped and counted in Stats.DroppedEvents, removal is never blocked.
+// Channel is closed by Shutdown after final events are sent.
+func (c *SafeDbMapCache) Events() <-chan Event {
+	return c.events
+}
+
+// emitEvent - sends event without blocking, drops it if buffer is full or channel is closed
+func (c *SafeDbMapCache) emitEvent(e Event) {
+	c.eventsMu.RLock()
+	defer c.eventsMu.RUnlock()
+
+	if c.eventsClosed {
+		return
+	}
+
+	select {
+	case c.events <- e:
+	default:
+		atomic.AddInt64(&c.droppedEvents, 1)
+	}
+}
+
+// closeEvents - closes events channel (once)
+func (c *SafeDbMapCache) closeEvents() {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	if c.eventsClosed {
+		return
+	}
+
+	c.eventsClosed = true
+	close(c.events)
+}
@@ -0,0 +1,129 @@
+package dbpool
+
+import (
+	"sort"
+	"time"
+)
+
+/////// On demand eviction ///////////
+
+// EvictStrategy - order of items removal by Evict
+type EvictStrategy int
+
+const (
+	// EvictOldest - items with the oldest connections (PoolItem.FirstCreated) are removed first
+	EvictOldest EvictStrategy = iota
+
+	// EvictLeastRecentlyUsed - least recently accessed items (PoolItem.Created) are removed first
+	EvictLeastRecentlyUsed
+)
+
+// Evict - closes and removes up to n items chosen by strategy, returns their keys.
+// Pinned (see SetOptions.Pinned), leased (see Lease) and kept by floor (see WithMinEntries) items are skipped.
+// Useful to shed connections when approaching database connection limit.
+func (c *SafeDbMapCache) Evict(n int, strategy EvictStrategy) []string {
+	if n <= 0 {
+		return nil
+	}
+
+	c.Lock()
+
+	kept := c.keptKeys()
+
+	keys := make([]string, 0, c.pool.len())
+	c.pool.each(func(k string, item PoolItem) {
+		if _, found := kept[k]; found {
+			return
+		}
+
+		keys = append(keys, k)
+	})
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := c.pool.item(keys[i]), c.pool.item(keys[j])
+		if strategy == EvictLeastRecentlyUsed {
+			return a.lastAccess().Before(b.lastAccess())
+		}
+
+		return a.FirstCreated.Before(b.FirstCreated)
+	})
+
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+
+	removed := make([]removedItem, 0, len(keys))
+	for _, k := range keys {
+		removed = append(removed, removedItem{key: k, item: c.pool.item(k), reason: ReasonEvicted})
+
+		c.deleteItem(k)
+	}
+
+	c.Unlock()
+
+	// display forms are taken before removed items are forgotten
+	keys = c.displayKeys(keys)
+
+	c.closeRemoved(removed)
+
+	return keys
+}
+
+// CloseIdle - closes and removes items not accessed (PoolItem.Created) for longer than threshold
+// regardless of their TTL, returns number of removed items. Items kept by Evict are skipped.
+// Useful to reclaim connections during low traffic periods.
+func (c *SafeDbMapCache) CloseIdle(threshold time.Duration) int {
+	if threshold <= 0 {
+		return 0
+	}
+
+	cutoff := c.now().Add(-threshold)
+
+	c.Lock()
+
+	kept := c.keptKeys()
+
+	var removed []removedItem
+	c.pool.each(func(k string, i PoolItem) {
+		if _, found := kept[k]; found || !i.lastAccess().Before(cutoff) {
+			return
+		}
+
+		removed = append(removed, removedItem{key: k, item: i, reason: ReasonEvicted})
+
+		c.deleteItem(k)
+	})
+
+	c.Unlock()
+
+	c.closeRemoved(removed)
+
+	return len(removed)
+}
+
+// DeleteFunc - closes and removes every item for which pred returns true, returns number of removed items.
+// Predicate is called under cache write lock, so it must not call cache methods. No-op on closed cache.
+func (c *SafeDbMapCache) DeleteFunc(pred func(key string, item PoolItem) bool) int {
+	if c.isClosed() {
+		return 0
+	}
+
+	c.Lock()
+
+	var removed []removedItem
+	c.pool.each(func(k string, i PoolItem) {
+		if !pred(k, i.synced()) {
+			return
+		}
+
+		removed = append(removed, removedItem{key: k, item: i, reason: ReasonDeleted})
+
+		c.deleteItem(k)
+	})
+
+	c.Unlock()
+
+	c.closeRemoved(removed)
+
+	return len(removed)
+}
@@ -0,0 +1,164 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Standby DSNs failover of registered connections ///////////
+
+// endpointState - registered DSN in use and number of failovers (see WithFailoverDSNs)
+type endpointState struct {
+	active    int
+	failovers int
+}
+
+// failoverConnect - returns ConnectFunc of registration trying its DSN and then standby ones
+// in order (see WithFailoverDSNs), remembers DSN connected
+func (c *SafeDbMapCache) failoverConnect(key string, reg *registration) ConnectFunc {
+	if len(reg.standby) == 0 {
+		return DSNConnect(reg.driver, reg.dsn)
+	}
+
+	dsns := append([]string{reg.dsn}, reg.standby...)
+
+	return func(ctx context.Context) (*sqlx.DB, error) {
+		var err error
+
+		for i, dsn := range dsns {
+			var db *sqlx.DB
+
+			db, err = sqlx.ConnectContext(ctx, reg.driver, dsn)
+			if err == nil {
+				c.setEndpoint(key, reg, i)
+
+				return db, nil
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, err
+	}
+}
+
+// setEndpoint - remembers DSN of registration in use, counts switch to the next DSN as failover
+func (c *SafeDbMapCache) setEndpoint(key string, reg *registration, active int) {
+	c.registryMu.Lock()
+
+	failover := active > reg.active
+	if failover {
+		reg.failovers++
+	}
+
+	reg.active = active
+
+	c.registryMu.Unlock()
+
+	if failover {
+		Logger.Warningf("db connection of key %s failed over to standby DSN #%d", c.redact(c.hashKey(key)), active)
+	}
+}
+
+// endpoints - returns DSN states of registrations with standby DSNs by internal key
+func (c *SafeDbMapCache) endpoints() map[string]endpointState {
+	c.registryMu.Lock()
+	defer c.registryMu.Unlock()
+
+	endpoints := make(map[string]endpointState)
+	for k, reg := range c.registry {
+		if len(reg.standby) == 0 {
+			continue
+		}
+
+		endpoints[c.hashKey(k)] = endpointState{active: reg.active, failovers: reg.failovers}
+	}
+
+	return endpoints
+}
+
+// failbackLoop - periodically probes preferred DSNs of failed over registrations until Shutdown (see WithFailback)
+func (c *SafeDbMapCache) failbackLoop() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.failbackInterval):
+		}
+
+		c.failbackCycle()
+	}
+}
+
+// failbackCycle - reconnects stored connections of failed over registrations
+// which preferred DSNs are reachable again
+func (c *SafeDbMapCache) failbackCycle() {
+	type target struct {
+		key    string
+		driver string
+		dsns   []string
+	}
+
+	c.registryMu.Lock()
+
+	var targets []target
+	for k, reg := range c.registry {
+		if reg.active == 0 {
+			continue
+		}
+
+		dsns := append([]string{reg.dsn}, reg.standby...)
+		targets = append(targets, target{key: k, driver: reg.driver, dsns: dsns[:reg.active]})
+	}
+
+	c.registryMu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].key < targets[j].key
+	})
+
+	for _, t := range targets {
+		if !probeDSNs(t.driver, t.dsns) {
+			continue
+		}
+
+		key := c.hashKey(t.key)
+
+		db, found, _ := c.getWith(key, GetOptions{})
+		if !found {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+		_, err := c.reconnect(ctx, key, db, ReasonFailback)
+		cancel()
+
+		if err != nil {
+			Logger.Warningf("db connection of key %s failback error: %s", c.redact(key), err.Error())
+		}
+	}
+}
+
+// probeDSNs - returns true if any of dsns is reachable
+func probeDSNs(driver string, dsns []string) bool {
+	for _, dsn := range dsns {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultKeepAlivePingTimeout)
+		db, err := sqlx.ConnectContext(ctx, driver, dsn)
+		cancel()
+
+		if err == nil {
+			_ = db.Close()
+
+			return true
+		}
+	}
+
+	return false
+}
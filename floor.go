@@ -0,0 +1,63 @@
+package dbpool
+
+import (
+	"sort"
+)
+
+/////// Warm connections floor ///////////
+
+// warmKeys - returns keys of items kept by GC on TTL expiration: pinned ones (see SetOptions.Pinned)
+// and min entries of the most recently used ones (see WithMinEntries). Must be called under lock.
+func (c *SafeDbMapCache) warmKeys() map[string]struct{} {
+	warm := make(map[string]struct{})
+
+	keys := make([]string, 0, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		if i.pinned {
+			warm[k] = struct{}{}
+			return
+		}
+
+		keys = append(keys, k)
+	})
+
+	if c.minEntries <= len(warm) {
+		return warm
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return c.pool.item(keys[i]).lastAccess().After(c.pool.item(keys[j]).lastAccess())
+	})
+
+	if n := c.minEntries - len(warm); n < len(keys) {
+		keys = keys[:n]
+	}
+
+	for _, k := range keys {
+		warm[k] = struct{}{}
+	}
+
+	return warm
+}
+
+// keepWarm - renews deadlines of expired warm item instead of its removal (must be called under write lock)
+func (c *SafeDbMapCache) keepWarm(key string, item PoolItem) {
+	item.renewDeadlines(c.now())
+
+	c.pool.set(key, item)
+}
+
+// keptKeys - returns keys of items skipped by evictions making room or shedding connections
+// (Evict, CloseIdle, WithMaxItems, WithConnBudget): warm ones (see warmKeys) and leased ones
+// (see Lease). Must be called under lock.
+func (c *SafeDbMapCache) keptKeys() map[string]struct{} {
+	kept := c.warmKeys()
+
+	c.pool.each(func(k string, i PoolItem) {
+		if c.leases[i.Db] > 0 {
+			kept[k] = struct{}{}
+		}
+	})
+
+	return kept
+}
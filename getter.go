@@ -44,3 +44,103 @@ func GetConnectionByParams(Ctx context.Context, connCache *SafeDbMapCache,
 
 	return conn, nil
 }
+
+// GetOrCreate - get *sqlx.DB from cache (extends item expiration) or create it with factory and put into cache.
+// Concurrent calls for the same key run factory once, created is true only for the caller whose
+// factory ran (useful for one-time connection initialization). Factory is also used to reconnect
+// dead connection (see SetOptions.Connect), failed factory call is retried according to retry
+// policy (see WithConnectRetry). Returns ErrPoolFull without factory call if pool is full and
+// rejects new items (see WithMaxItems).
+func (c *SafeDbMapCache) GetOrCreate(ctx context.Context, key string, duration time.Duration,
+	factory func(ctx context.Context) (*sqlx.DB, error)) (db *sqlx.DB, created bool, err error) {
+
+	if db, found := c.Get(key); found {
+		return db, false, nil
+	}
+
+	if c.isClosed() {
+		return nil, false, ErrClosed
+	}
+
+	// in-flight calls are tracked by internal key, so raw secret keys aren't kept (see WithHashedKeys)
+	id := c.hashKey(key)
+
+	// fail fast, TrySet checks it again
+	if c.rejectsNew(id) {
+		return nil, false, ErrPoolFull
+	}
+
+	c.createMu.Lock()
+
+	call, found := c.creating[id]
+	if found {
+		c.createMu.Unlock()
+
+		// wait for concurrent factory call
+		select {
+		case <-call.done:
+			return call.db, false, call.err
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+
+	call = &dialCall{done: make(chan struct{})}
+	c.creating[id] = call
+
+	c.createMu.Unlock()
+
+	call.db, call.err = c.connectRetry(ctx, id, factory)
+	if call.err == nil {
+		call.err = c.TrySet(key, call.db, duration, SetOptions{Connect: factory})
+		if call.err != nil {
+			_ = c.closeDb(id, call.db)
+			call.db = nil
+		}
+	}
+
+	c.createMu.Lock()
+	delete(c.creating, id)
+	c.createMu.Unlock()
+
+	close(call.done)
+
+	return call.db, call.err == nil, call.err
+}
+
+// Open - opens *sqlx.DB with sqlx.Open (without connecting) and stores it by key, replaced connection
+// of key is closed. Driver and dsn are kept to reconnect dead connection (see SetOptions.Connect).
+// Connection not stored (see TrySet) is closed and error is returned.
+func (c *SafeDbMapCache) Open(key, driverName, dsn string, duration time.Duration) (*sqlx.DB, error) {
+	db, err := sqlx.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.storeOpened(key, db, duration, DSNConnect(driverName, dsn))
+}
+
+// Connect - like Open, but connection is checked with ping before it is stored. Failed connect is
+// retried according to retry policy (see WithConnectRetry).
+func (c *SafeDbMapCache) Connect(ctx context.Context, key, driverName, dsn string, duration time.Duration) (*sqlx.DB, error) {
+	connect := DSNConnect(driverName, dsn)
+
+	db, err := c.connectRetry(ctx, c.hashKey(key), connect)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.storeOpened(key, db, duration, connect)
+}
+
+// storeOpened - stores connection opened by cache by key, closes it if it isn't stored
+func (c *SafeDbMapCache) storeOpened(key string, db *sqlx.DB, duration time.Duration, connect ConnectFunc) (*sqlx.DB, error) {
+	err := c.TrySet(key, db, duration, SetOptions{Connect: connect})
+	if err != nil {
+		_ = c.closeDb(c.hashKey(key), db)
+
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -1,12 +1,16 @@
 module github.com/NGRsoftlab/ngr-dbpool
 
-go 1.13
+go 1.18
 
 require (
-	github.com/NGRsoftlab/error-lib v1.0.2
 	github.com/NGRsoftlab/ngr-logging v1.0.0
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/lib/pq v1.10.2
 	github.com/mailru/go-clickhouse v1.7.0
+)
+
+require (
+	github.com/google/uuid v1.2.0 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
+	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
 )
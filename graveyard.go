@@ -0,0 +1,75 @@
+package dbpool
+
+import (
+	"time"
+)
+
+/////// Delayed close of GC evicted items ("graveyard") ///////////
+
+// pendingClose - GC evicted item waiting for close (see WithCloseDelay)
+type pendingClose struct {
+	removed removedItem
+	timer   *time.Timer
+}
+
+// closeEvicted - closes GC evicted items immediately or after close delay (if set).
+// Must be called without lock.
+func (c *SafeDbMapCache) closeEvicted(removed []removedItem) {
+	if c.closeDelay <= 0 {
+		c.closeRemoved(removed)
+		return
+	}
+
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	for _, r := range removed {
+		c.pendingSeq++
+		id := c.pendingSeq
+
+		c.pending[id] = &pendingClose{
+			removed: r,
+			timer: time.AfterFunc(c.closeDelay, func() {
+				c.runPendingClose(id)
+			}),
+		}
+	}
+}
+
+// runPendingClose - closes pending item by id (if it wasn't closed yet)
+func (c *SafeDbMapCache) runPendingClose(id uint64) {
+	c.pendingMu.Lock()
+	p, ok := c.pending[id]
+	delete(c.pending, id)
+	c.pendingMu.Unlock()
+
+	if ok {
+		_ = c.closeItem(p.removed)
+	}
+}
+
+// drainPending - closes all pending items immediately
+func (c *SafeDbMapCache) drainPending() {
+	c.pendingMu.Lock()
+
+	removed := make([]removedItem, 0, len(c.pending))
+	for id, p := range c.pending {
+		p.timer.Stop()
+
+		removed = append(removed, p.removed)
+
+		delete(c.pending, id)
+	}
+
+	c.pendingMu.Unlock()
+
+	c.closeRemoved(removed)
+}
+
+// pendingCount - returns number of items waiting for close
+func (c *SafeDbMapCache) pendingCount() int {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+
+	return len(c.pending)
+}
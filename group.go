@@ -0,0 +1,451 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Connection groups (replicas of one logical database by one key) ///////////
+
+// dbGroup - other connections of item group with round-robin cursor shared by item copies.
+// Members aren't changed in place: dropped member makes new group.
+type dbGroup struct {
+	members []*sqlx.DB
+	next    uint32
+
+	// members are replicas of item Db (see SetRoles): they are read by GetReader only
+	// and marked down instead of being dropped on failed health check
+	roles bool
+	down  []int32
+
+	// replicas read weights (see UpdateWeight)
+	weights []int32
+}
+
+// newDbGroup - returns group of members (nil for no members, i.e. single connection item)
+func newDbGroup(members []*sqlx.DB) *dbGroup {
+	if len(members) == 0 {
+		return nil
+	}
+
+	return &dbGroup{members: members}
+}
+
+// newRoleGroup - returns role group of weighted replicas (nil for no replicas, i.e. primary only item)
+func newRoleGroup(replicas []Replica) *dbGroup {
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	g := &dbGroup{
+		members: make([]*sqlx.DB, 0, len(replicas)),
+		roles:   true,
+		down:    make([]int32, len(replicas)),
+		weights: make([]int32, 0, len(replicas)),
+	}
+
+	for _, r := range replicas {
+		g.members = append(g.members, r.Db)
+		g.weights = append(g.weights, clampWeight(r.Weight))
+	}
+
+	return g
+}
+
+// clampWeight - returns replica weight, negative weight is zero
+func clampWeight(w int) int32 {
+	if w < 0 {
+		return 0
+	}
+
+	return int32(w)
+}
+
+// isRoles - returns true if item is primary with replicas (see SetRoles)
+func (i PoolItem) isRoles() bool {
+	return i.group != nil && i.group.roles
+}
+
+// groupMembers - returns other connections of item group (nil for single connection item)
+func (i PoolItem) groupMembers() []*sqlx.DB {
+	if i.group == nil {
+		return nil
+	}
+
+	return i.group.members
+}
+
+// pick - returns next connection of item group round-robin (Db for single connection item and primary with replicas)
+func (i PoolItem) pick() *sqlx.DB {
+	if i.group == nil || i.group.roles {
+		return i.Db
+	}
+
+	n := atomic.AddUint32(&i.group.next, 1) - 1
+
+	idx := int(n % uint32(len(i.group.members)+1))
+	if idx == 0 {
+		return i.Db
+	}
+
+	return i.group.members[idx-1]
+}
+
+// writer - returns item Db (primary of role group)
+func (i PoolItem) writer() *sqlx.DB {
+	return i.Db
+}
+
+// reader - returns next healthy replica by weighted round-robin, healthy zero weight replica
+// if others are down and primary if no replica is healthy (other items are read with pick)
+func (i PoolItem) reader() *sqlx.DB {
+	if !i.isRoles() {
+		return i.pick()
+	}
+
+	g := i.group
+	n := atomic.AddUint32(&g.next, 1) - 1
+
+	weights := make([]uint32, len(g.members))
+	for k := range g.members {
+		if atomic.LoadInt32(&g.down[k]) == 0 {
+			weights[k] = uint32(atomic.LoadInt32(&g.weights[k]))
+		}
+	}
+
+	if k := weightedIndex(n, weights); k >= 0 {
+		return g.members[k]
+	}
+
+	// zero weight replicas are kept for failover
+	start := int(n % uint32(len(g.members)))
+	for j := range g.members {
+		k := (start + j) % len(g.members)
+
+		if atomic.LoadInt32(&g.down[k]) == 0 {
+			return g.members[k]
+		}
+	}
+
+	return i.Db
+}
+
+// weightedIndex - returns index of draw n by weighted round-robin: every index with
+// positive weight w is returned w times per sum of weights draws, -1 if all weights are zero
+func weightedIndex(n uint32, weights []uint32) int {
+	var total uint32
+	for _, w := range weights {
+		total += w
+	}
+
+	if total == 0 {
+		return -1
+	}
+
+	pos := n % total
+	for k, w := range weights {
+		if pos < w {
+			return k
+		}
+
+		pos -= w
+	}
+
+	return -1
+}
+
+// replicas - returns number of replicas of primary (see SetRoles)
+func (i PoolItem) replicas() int {
+	if !i.isRoles() {
+		return 0
+	}
+
+	return len(i.group.members)
+}
+
+// replicasDown - returns number of replicas failed the last health check
+func (i PoolItem) replicasDown() int {
+	if !i.isRoles() {
+		return 0
+	}
+
+	var n int
+	for k := range i.group.down {
+		n += int(atomic.LoadInt32(&i.group.down[k]))
+	}
+
+	return n
+}
+
+// owns - returns true if db is item connection or member of its group
+func (i PoolItem) owns(db *sqlx.DB) bool {
+	if i.Db == db {
+		return true
+	}
+
+	for _, m := range i.groupMembers() {
+		if m == db {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetGroup - setting group of connections to replicas of one logical database by key.
+// Get-like methods return group connections round-robin, GetAll returns whole group.
+// Removed item closes every member, dead member is dropped by health checks (see WithHealthCheck)
+// and GetAlive keeping the rest of group. Group members must not be stored by other keys.
+// Returns ErrEmptyGroup if dbs has no connections and TrySet errors.
+func (c *SafeDbMapCache) SetGroup(key string, dbs []*sqlx.DB, duration time.Duration) error {
+	members := make([]*sqlx.DB, 0, len(dbs))
+	seen := make(map[*sqlx.DB]struct{}, len(dbs))
+
+	for _, db := range dbs {
+		if _, found := seen[db]; found || db == nil {
+			continue
+		}
+
+		seen[db] = struct{}{}
+		members = append(members, db)
+	}
+
+	if len(members) == 0 {
+		return ErrEmptyGroup
+	}
+
+	return c.trySet(key, members[0], newDbGroup(members[1:]), duration, SetOptions{})
+}
+
+// Replica - replica connection with its read weight (see SetWeightedRoles)
+type Replica struct {
+	Db *sqlx.DB
+
+	// Weight - share of GetReader reads, zero weight replica is read only if others are down
+	Weight int
+}
+
+// SetRoles - setting primary (writer) connection with its replicas (readers) by key.
+// GetWriter and Get-like methods return primary, GetReader returns replicas round-robin
+// (all replicas have weight 1, see SetWeightedRoles).
+// TTL and eviction apply to the whole group: removed item closes every connection.
+// Replica failed health check (see WithHealthCheck) isn't read until its next successful check,
+// failed primary is handled by health check policy. Replicas must not be stored by other keys.
+// Returns TrySet errors.
+func (c *SafeDbMapCache) SetRoles(key string, primary *sqlx.DB, replicas []*sqlx.DB, duration time.Duration) error {
+	weighted := make([]Replica, 0, len(replicas))
+	for _, db := range replicas {
+		weighted = append(weighted, Replica{Db: db, Weight: 1})
+	}
+
+	return c.SetWeightedRoles(key, primary, weighted, duration)
+}
+
+// SetWeightedRoles - setting primary connection with its replicas by key like SetRoles,
+// GetReader returns replicas by weighted round-robin: replica gets its weight share of reads
+// (see UpdateWeight). Duplicate and nil replicas are skipped, replica index is its position among left ones.
+func (c *SafeDbMapCache) SetWeightedRoles(key string, primary *sqlx.DB, replicas []Replica, duration time.Duration) error {
+	if primary == nil {
+		return ErrEmptyGroup
+	}
+
+	members := make([]Replica, 0, len(replicas))
+	seen := map[*sqlx.DB]struct{}{primary: {}}
+
+	for _, r := range replicas {
+		if _, found := seen[r.Db]; found || r.Db == nil {
+			continue
+		}
+
+		seen[r.Db] = struct{}{}
+		members = append(members, r)
+	}
+
+	return c.trySet(key, primary, newRoleGroup(members), duration, SetOptions{})
+}
+
+// UpdateWeight - changing read weight of replica with index (see SetWeightedRoles) of key in place,
+// negative weight is zero. Returns ErrKeyNotFound if key is not found, ErrNoReplica if item has no such replica.
+func (c *SafeDbMapCache) UpdateWeight(key string, index int, w int) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	key = c.hashKey(key)
+
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	if !item.isRoles() || index < 0 || index >= len(item.group.weights) {
+		return ErrNoReplica
+	}
+
+	atomic.StoreInt32(&item.group.weights[index], clampWeight(w))
+
+	return nil
+}
+
+// GetWriter - getting primary connection by key (see SetRoles), extends item expiration.
+// Item stored with Set returns its connection.
+func (c *SafeDbMapCache) GetWriter(key string) (*sqlx.DB, bool) {
+	return c.getRole(key, PoolItem.writer)
+}
+
+// GetReader - getting replica connection by key round-robin (see SetRoles), extends item expiration.
+// Primary is returned if no replica is healthy, item stored with Set returns its connection.
+func (c *SafeDbMapCache) GetReader(key string) (*sqlx.DB, bool) {
+	return c.getRole(key, PoolItem.reader)
+}
+
+// getRole - getting connection chosen by role of item by key
+func (c *SafeDbMapCache) getRole(key string, choose func(PoolItem) *sqlx.DB) (*sqlx.DB, bool) {
+	key = c.hashKey(key)
+
+	db, res := c.readWith(key, true, choose)
+
+	found := res == GetHit
+	if found && c.guardClosed(key, db) {
+		db, found = nil, false
+	}
+
+	c.getHook(key, found)
+
+	return db, found
+}
+
+// GetAll - getting all connections of key group (see SetGroup, SetRoles - primary goes first),
+// extends item expiration.
+// Single connection item returns its only connection.
+func (c *SafeDbMapCache) GetAll(key string) ([]*sqlx.DB, bool) {
+	key = c.hashKey(key)
+
+	dbs := c.readAll(key)
+
+	c.getHook(key, dbs != nil)
+
+	return dbs, dbs != nil
+}
+
+// readAll - getting all connections of not expired item extending its expiration (see read)
+func (c *SafeDbMapCache) readAll(key string) []*sqlx.DB {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(key)
+	if !found || item.suspect() {
+		return nil
+	}
+
+	now := c.now()
+
+	if deadline := item.deadline(); deadline > 0 && now.UnixNano() > deadline {
+		return nil
+	}
+
+	item.touch(now)
+
+	return append([]*sqlx.DB{item.Db}, item.groupMembers()...)
+}
+
+// dropMember - removes db from item group, the first of left members takes
+// place of dropped item Db (must be called under write lock)
+func (c *SafeDbMapCache) dropMember(key string, item PoolItem, db *sqlx.DB) {
+	left := make([]*sqlx.DB, 0, len(item.group.members))
+	for _, m := range append([]*sqlx.DB{item.Db}, item.group.members...) {
+		if m != db {
+			left = append(left, m)
+		}
+	}
+
+	item.Db = left[0]
+	item.group = newDbGroup(left[1:])
+
+	c.insertItem(key, item)
+}
+
+// closeMember - closes connection dropped from key group unless it is still in use
+// (leased or stored by key again). Must be called without lock.
+func (c *SafeDbMapCache) closeMember(key string, db *sqlx.DB, reason EvictReason) {
+	Logger.Warningf("db connection of key %s is dropped from group: %s", c.redact(key), reason.String())
+
+	if c.shared(db) {
+		return
+	}
+
+	err := c.closeDb(key, db)
+	if err != nil {
+		Logger.Warningf("db connection of key %s close error: %s", c.redact(key), err.Error())
+	}
+}
+
+// closeMembers - closes group members of removed item not stored by key again,
+// returns the first close error. Must be called without lock.
+func (c *SafeDbMapCache) closeMembers(key string, members []*sqlx.DB) error {
+	var firstErr error
+
+	for _, m := range members {
+		if c.shared(m) {
+			continue
+		}
+
+		if err := c.closeDb(key, m); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// checkMembers - pings group members of key item other than db. Dead replicas are marked down
+// (see SetRoles), dead members of other groups are dropped unless they are leased or
+// policy is FailMarkUnhealthy (see WithHealthCheck)
+func (c *SafeDbMapCache) checkMembers(key string, db *sqlx.DB, timeout time.Duration) {
+	c.RLock()
+	item, found := c.pool.get(key)
+	c.RUnlock()
+
+	if !found || item.Db != db {
+		return
+	}
+
+	if !item.isRoles() && c.healthFail == FailMarkUnhealthy {
+		return
+	}
+
+	for n, m := range item.groupMembers() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := c.tracePing(ctx, key, m)
+		cancel()
+
+		if item.isRoles() {
+			var down int32
+			if err != nil {
+				down = 1
+			}
+
+			if atomic.SwapInt32(&item.group.down[n], down) == 0 && down == 1 {
+				Logger.Warningf("db replica of key %s health check error: %s", c.redact(key), err.Error())
+			}
+
+			continue
+		}
+
+		if err == nil || c.leased(m) {
+			continue
+		}
+
+		Logger.Warningf("db connection of key %s group member health check error: %s", c.redact(key), err.Error())
+
+		c.evictIfSame(key, m, ReasonHealthCheck)
+	}
+}
@@ -0,0 +1,54 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Externally closed connections guard ///////////
+
+// Cached *sqlx.DB is shared: closing it outside of cache (instead of Delete) breaks every
+// following Get of its key. With WithCloseGuard cache detects such connections on read and GC,
+// logs them and evicts them (ReasonClosedExternally) instead of returning closed connection.
+
+// isDbClosed - returns true if db is closed. Check is cheap and makes no network round trip:
+// ping with cancelled context fails on closed check before acquiring connection.
+func isDbClosed(db *sqlx.DB) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := db.PingContext(ctx)
+
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+// guardClosed - evicts item if its connection is closed outside of cache (see WithCloseGuard),
+// returns true if connection is closed
+func (c *SafeDbMapCache) guardClosed(key string, db *sqlx.DB) bool {
+	if !c.closeGuard || !isDbClosed(db) {
+		return false
+	}
+
+	Logger.Warningf("db connection of key %s is closed outside of pool", c.redact(key))
+
+	c.evictIfSame(key, db, ReasonClosedExternally)
+
+	return true
+}
+
+// guardSweep - evicts all items with connections closed outside of cache, returns their keys
+func (c *SafeDbMapCache) guardSweep() []string {
+	var evicted []string
+
+	for _, t := range c.liveTargets() {
+		if c.guardClosed(t.key, t.db) {
+			evicted = append(evicted, t.key)
+		}
+	}
+
+	return evicted
+}
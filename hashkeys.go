@@ -0,0 +1,84 @@
+package dbpool
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+/////// Hashed keys mode (see WithHashedKeys) ///////////
+
+// SHA256Key - returns hex encoded SHA-256 of key, default WithHashedKeys hash
+func SHA256Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// hashKey - returns internal key of key: key itself or its hash in hashed keys mode.
+// Namespace of namespaced key (see NamespaceKey) is kept, so namespace operations still work.
+func (c *SafeDbMapCache) hashKey(key string) string {
+	if c.hashKeys == nil {
+		return key
+	}
+
+	if ns, k, ok := splitNamespaceKey(key); ok {
+		return NamespaceKey(ns, c.hashKeys(k))
+	}
+
+	return c.hashKeys(key)
+}
+
+// rememberDisplay - remembers redacted display form of raw key by its internal key
+func (c *SafeDbMapCache) rememberDisplay(key, raw string) {
+	if c.hashKeys == nil || !c.keepDisplay {
+		return
+	}
+
+	display := raw
+	if c.redactKey != nil {
+		display = c.redactKey(raw)
+	}
+
+	c.displayMu.Lock()
+	c.display[key] = display
+	c.displayMu.Unlock()
+}
+
+// forgetDisplay - forgets display form of removed item (if it wasn't set again)
+func (c *SafeDbMapCache) forgetDisplay(key string) {
+	if c.hashKeys == nil || !c.keepDisplay {
+		return
+	}
+
+	c.displayMu.Lock()
+	defer c.displayMu.Unlock()
+
+	if !c.has(key) {
+		delete(c.display, key)
+	}
+}
+
+// lookupDisplay - returns display form of internal key
+func (c *SafeDbMapCache) lookupDisplay(key string) (string, bool) {
+	c.displayMu.RLock()
+	display, ok := c.display[key]
+	c.displayMu.RUnlock()
+
+	return display, ok
+}
+
+// displayKeys - returns display forms of internal keys (keys themselves if display forms are not kept).
+// Must be called without cache lock.
+func (c *SafeDbMapCache) displayKeys(keys []string) []string {
+	if c.hashKeys == nil || !c.keepDisplay {
+		return keys
+	}
+
+	for i, k := range keys {
+		if display, ok := c.lookupDisplay(k); ok {
+			keys[i] = display
+		}
+	}
+
+	return keys
+}
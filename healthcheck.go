@@ -0,0 +1,325 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Health check retries ///////////
+
+// HealthRetry - retry settings of health check pings (see WithHealthCheckRetry)
+type HealthRetry struct {
+	// Attempts - number of failed pings after which connection is considered dead (1 - no retries)
+	Attempts int
+
+	// AttemptTimeout - timeout of single ping, zero - ping timeout of check
+	// (see WithHealthCheckOnGC, WithKeepAlive)
+	AttemptTimeout time.Duration
+
+	// MinBackoff, MaxBackoff - delay after failed ping, doubled after every attempt up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Strict - item isn't returned by Get-like reads while its failed ping is retried
+	Strict bool
+}
+
+// HealthAttempt - single health check ping of item (see ItemInfo.HealthChecks)
+type HealthAttempt struct {
+	At       time.Time
+	Duration time.Duration
+	Err      string // empty if ping succeeded
+}
+
+// checkHealth - pings target with retries (see WithHealthCheckRetry), records attempts on item.
+// Returns error of the last attempt.
+func (c *SafeDbMapCache) checkHealth(t pingTarget, timeout time.Duration) error {
+	attempts := c.healthRetry.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	if c.healthRetry.AttemptTimeout > 0 {
+		timeout = c.healthRetry.AttemptTimeout
+	}
+
+	backoff := c.healthRetry.MinBackoff
+
+	for i := 0; ; i++ {
+		at := c.now()
+		start := time.Now()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := t.db.PingContext(ctx)
+		cancel()
+
+		attempt := HealthAttempt{At: at, Duration: time.Since(start)}
+		if err != nil {
+			attempt.Err = err.Error()
+		}
+
+		suspect := err != nil && i+1 < attempts && c.healthRetry.Strict
+		if !c.recordHealth(t, attempt, i == 0, suspect) || err == nil || i+1 >= attempts {
+			return err
+		}
+
+		select {
+		case <-c.stop:
+			c.unsuspect(t)
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.healthRetry.MaxBackoff {
+			backoff = c.healthRetry.MaxBackoff
+		}
+	}
+}
+
+// recordHealth - appends health check attempt to history of item holding target connection
+// (first attempt starts new history) and sets its suspect flag (see HealthRetry.Strict).
+// Returns false if item was removed or replaced meanwhile.
+func (c *SafeDbMapCache) recordHealth(t pingTarget, attempt HealthAttempt, first, suspect bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool.get(t.key)
+	if !found || item.Db != t.db {
+		return false
+	}
+
+	if first {
+		item.healthChecks = nil
+	}
+
+	item.healthChecks = append(item.healthChecks, attempt)
+	item.setSuspect(suspect)
+
+	c.pool.set(t.key, item)
+
+	return true
+}
+
+// unsuspect - returns item holding target connection to Get-like reads (see HealthRetry.Strict)
+func (c *SafeDbMapCache) unsuspect(t pingTarget) {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(t.key)
+	if found && item.Db == t.db {
+		item.setSuspect(false)
+	}
+}
+
+// defaultVerifyConcurrency - number of VerifyAll pings running at once
+const defaultVerifyConcurrency = 8
+
+// VerifyOptions - VerifyAll settings
+type VerifyOptions struct {
+	// Concurrency - number of pings running at once (8 by default)
+	Concurrency int
+
+	// Evict - close and remove items failed ping
+	Evict bool
+}
+
+// VerifyAll - pings connections of all live items concurrently (each ping is bounded by ctx),
+// returns ping results by key: nil for alive connection and ping error otherwise.
+// Item expiration isn't extended, failed pings of items removed or replaced during check are omitted.
+func (c *SafeDbMapCache) VerifyAll(ctx context.Context, opts ...VerifyOptions) map[string]error {
+	var o VerifyOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultVerifyConcurrency
+	}
+
+	targets := c.liveTargets()
+
+	keys := make([]string, len(targets))
+	for i, t := range targets {
+		keys[i] = t.key
+	}
+
+	// display keys are resolved before evictions forget them
+	keys = c.displayKeys(keys)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(targets))
+		sem     = make(chan struct{}, o.Concurrency)
+	)
+
+	for i, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(display string, t pingTarget) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := c.tracePing(ctx, t.key, t.db)
+
+			// concurrently removed item is closed - it's not a failure of live item
+			if err != nil && !c.holds(t) {
+				return
+			}
+
+			if err != nil && o.Evict {
+				c.evictIfSame(t.key, t.db, ReasonPingFailed)
+			}
+
+			mu.Lock()
+			results[display] = err
+			mu.Unlock()
+		}(keys[i], t)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// holds - returns true if target key item still holds target connection
+func (c *SafeDbMapCache) holds(t pingTarget) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(t.key)
+
+	return found && item.Db == t.db
+}
+
+// FailPolicy - action applied to item failed background health check (see WithHealthCheck)
+type FailPolicy int
+
+const (
+	// FailEvict - item is closed and removed (leased items are skipped until released, see Lease,
+	// pinned ones are kept, see SetOptions.Pinned),
+	// dead member of item group is dropped from it (see SetGroup)
+	FailEvict FailPolicy = iota
+
+	// FailReconnect - connection is replaced by new one if item has connect func
+	// (see SetOptions.Connect, RegisterDSN), otherwise item is evicted
+	FailReconnect
+
+	// FailMarkUnhealthy - item is kept and marked unhealthy (see ItemInfo.Unhealthy)
+	// until its next successful check
+	FailMarkUnhealthy
+)
+
+// String - returns policy name
+func (p FailPolicy) String() string {
+	switch p {
+	case FailEvict:
+		return "evict"
+	case FailReconnect:
+		return "reconnect"
+	case FailMarkUnhealthy:
+		return "mark-unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// healthLoop - periodically checks all live items until Shutdown (see WithHealthCheck)
+func (c *SafeDbMapCache) healthLoop() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.healthInterval):
+		}
+
+		c.healthCycle()
+	}
+}
+
+// healthCycle - pings all live items once, applies fail policy to failed ones
+func (c *SafeDbMapCache) healthCycle() {
+	timeout := defaultKeepAlivePingTimeout
+	if c.healthInterval < timeout {
+		timeout = c.healthInterval
+	}
+
+	for _, t := range c.liveTargets() {
+		// dead members of item group are dropped keeping the rest (see SetGroup, SetRoles)
+		c.checkMembers(t.key, t.db, timeout)
+
+		err := c.checkHealth(t, timeout)
+
+		if !c.markHealth(t, err == nil) || err == nil {
+			continue
+		}
+
+		Logger.Warningf("db connection of key %s health check error: %s", c.redact(t.key), err.Error())
+
+		policy := c.healthFail
+		if policy == FailReconnect && c.connectFunc(t.key, t.db) == nil {
+			policy = FailEvict
+		}
+
+		switch policy {
+		case FailReconnect:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, _ = c.reconnect(ctx, t.key, t.db, ReasonHealthCheck)
+			cancel()
+		case FailEvict:
+			if c.leased(t.db) || c.pinned(t) {
+				continue
+			}
+
+			c.evictIfSame(t.key, t.db, ReasonHealthCheck)
+		}
+
+		if c.hooks != nil && c.hooks.OnHealthCheckFail != nil {
+			c.runHook("OnHealthCheckFail", func() { c.hooks.OnHealthCheckFail(t.key, policy, err) })
+		}
+	}
+}
+
+// markHealth - sets unhealthy flag of item holding target connection.
+// Returns false if item was removed or replaced meanwhile.
+func (c *SafeDbMapCache) markHealth(t pingTarget, ok bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool.get(t.key)
+	if !found || item.Db != t.db {
+		return false
+	}
+
+	item.unhealthy = !ok
+	c.pool.set(t.key, item)
+
+	return true
+}
+
+// leased - returns true if connection is held by lease (see Lease)
+func (c *SafeDbMapCache) leased(db *sqlx.DB) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.leases[db] > 0
+}
+
+// pinned - returns true if target connection belongs to pinned item (see SetOptions.Pinned)
+func (c *SafeDbMapCache) pinned(t pingTarget) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(t.key)
+
+	return found && item.Db == t.db && item.pinned
+}
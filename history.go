@@ -0,0 +1,144 @@
+package dbpool
+
+import (
+	"sync"
+	"time"
+)
+
+/////// Recent operations history (see WithHistory) ///////////
+
+// HistoryOp - recorded pool operation
+type HistoryOp string
+
+const (
+	// HistorySet - item is set
+	HistorySet HistoryOp = "set"
+
+	// HistoryGetMiss - item is not found by Get-like method
+	HistoryGetMiss HistoryOp = "get-miss"
+
+	// HistoryDelete - item is removed by Delete-like method
+	HistoryDelete HistoryOp = "delete"
+
+	// HistoryEvict - item is removed by cache itself (GC, failed ping, etc. - see Reason)
+	HistoryEvict HistoryOp = "evict"
+)
+
+// HistoryEntry - recorded pool operation
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Op     HistoryOp `json:"op"`
+	Key    string    `json:"key"`              // redacted key (see WithKeyRedactor)
+	Reason string    `json:"reason,omitempty"` // removal reason (see EvictReason)
+
+	// removal reason value (eviction log only, see RecentEvictions)
+	reason EvictReason
+}
+
+// history - fixed size ring buffer of recent operations
+type history struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	full    bool
+}
+
+// newHistory - initializing a new history of size n
+func newHistory(n int) *history {
+	return &history{entries: make([]HistoryEntry, n)}
+}
+
+// add - records entry overwriting the oldest one
+func (h *history) add(e HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = e
+
+	h.next++
+	if h.next == len(h.entries) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// list - returns recorded entries from the oldest to the newest
+func (h *history) list() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]HistoryEntry(nil), h.entries[:h.next]...)
+	}
+
+	res := make([]HistoryEntry, 0, len(h.entries))
+	res = append(res, h.entries[h.next:]...)
+	res = append(res, h.entries[:h.next]...)
+
+	return res
+}
+
+// History - returns recent pool operations from the oldest to the newest (nil if history is disabled)
+func (c *SafeDbMapCache) History() []HistoryEntry {
+	if c.history == nil {
+		return nil
+	}
+
+	return c.history.list()
+}
+
+// record - records operation if history is enabled
+func (c *SafeDbMapCache) record(op HistoryOp, key string) {
+	if c.history == nil {
+		return
+	}
+
+	c.history.add(HistoryEntry{Time: c.now(), Op: op, Key: c.redact(key)})
+}
+
+// recordRemoval - records item removal if history or eviction log is enabled
+func (c *SafeDbMapCache) recordRemoval(key string, reason EvictReason) {
+	if c.history == nil && c.evictionLog == nil {
+		return
+	}
+
+	op := HistoryEvict
+	if reason == ReasonDeleted {
+		op = HistoryDelete
+	}
+
+	e := HistoryEntry{Time: c.now(), Op: op, Key: c.redact(key), Reason: reason.String()}
+
+	if c.history != nil {
+		c.history.add(e)
+	}
+
+	if c.evictionLog != nil {
+		e.reason = reason
+		c.evictionLog.add(e)
+	}
+}
+
+// EvictionRecord - recorded item removal (see RecentEvictions)
+type EvictionRecord struct {
+	Key    string // redacted key (see WithKeyRedactor)
+	Reason EvictReason
+	Time   time.Time
+}
+
+// RecentEvictions - returns recent item removals of any kind (expiration, Delete, LRU eviction, etc.)
+// from the oldest to the newest (nil if eviction log is disabled, see WithEvictionLog)
+func (c *SafeDbMapCache) RecentEvictions() []EvictionRecord {
+	if c.evictionLog == nil {
+		return nil
+	}
+
+	entries := c.evictionLog.list()
+
+	records := make([]EvictionRecord, len(entries))
+	for i, e := range entries {
+		records[i] = EvictionRecord{Key: e.Key, Reason: e.reason, Time: e.Time}
+	}
+
+	return records
+}
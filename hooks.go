@@ -0,0 +1,71 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"sync/atomic"
+	"time"
+)
+
+/////// Operation hooks ///////////
+
+// Hooks - optional callbacks of pool operations (metrics, tracing, audit logs), see WithHooks.
+// Hooks are called without cache lock, hook panic is recovered and logged.
+type Hooks struct {
+	// OnSet - called after item is set
+	OnSet func(key string, item PoolItem)
+
+	// OnGetHit - called when Get-like method returns item
+	OnGetHit func(key string)
+
+	// OnGetMiss - called when Get-like method doesn't find item (or its ping failed)
+	OnGetMiss func(key string)
+
+	// OnDelete - called after item is removed by Delete or DeleteMany with close error
+	OnDelete func(key string, err error)
+
+	// OnGCRun - called after every GC cycle with evicted keys and cycle duration
+	OnGCRun func(evicted []string, took time.Duration)
+
+	// OnHealthCheckFail - called when background health check ping of item failed, with policy
+	// applied to it (see WithHealthCheck)
+	OnHealthCheckFail func(key string, policy FailPolicy, err error)
+}
+
+// getHook - counts read, notifies eviction policy and calls OnGetHit or OnGetMiss hook
+// (and records miss into history)
+func (c *SafeDbMapCache) getHook(key string, hit bool) {
+	if hit {
+		atomic.AddInt64(&c.hits, 1)
+
+		if c.policy != nil {
+			c.policy.OnAccess(key)
+		}
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+
+		c.record(HistoryGetMiss, key)
+	}
+
+	if c.hooks == nil {
+		return
+	}
+
+	switch {
+	case hit && c.hooks.OnGetHit != nil:
+		c.runHook("OnGetHit", func() { c.hooks.OnGetHit(key) })
+	case !hit && c.hooks.OnGetMiss != nil:
+		c.runHook("OnGetMiss", func() { c.hooks.OnGetMiss(key) })
+	}
+}
+
+// runHook - calls hook recovering its panic
+func (c *SafeDbMapCache) runHook(name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Errorf("db pool %s hook panic: %v", name, r)
+		}
+	}()
+
+	hook()
+}
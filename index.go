@@ -0,0 +1,88 @@
+package dbpool
+
+import (
+	"sort"
+)
+
+/////// Secondary indexes ///////////
+
+// secondaryIndex - keys of items by value extracted from them (see WithIndex)
+type secondaryIndex struct {
+	extract func(item PoolItem) string
+
+	// indexed value -> set of keys, key -> its indexed value
+	keys   map[string]map[string]struct{}
+	values map[string]string
+}
+
+// indexItem - adds key of item to secondary indexes replacing its previous value
+// (must be called under write lock)
+func (c *SafeDbMapCache) indexItem(key string, item PoolItem) {
+	for _, idx := range c.indexes {
+		idx.remove(key)
+
+		value := idx.extract(item.synced())
+		if value == "" {
+			continue
+		}
+
+		keys, found := idx.keys[value]
+		if !found {
+			keys = make(map[string]struct{})
+			idx.keys[value] = keys
+		}
+
+		keys[key] = struct{}{}
+		idx.values[key] = value
+	}
+}
+
+// unindexItem - removes key from secondary indexes (must be called under write lock)
+func (c *SafeDbMapCache) unindexItem(key string) {
+	for _, idx := range c.indexes {
+		idx.remove(key)
+	}
+}
+
+// remove - removes key from index
+func (idx *secondaryIndex) remove(key string) {
+	value, found := idx.values[key]
+	if !found {
+		return
+	}
+
+	delete(idx.values, key)
+
+	keys := idx.keys[value]
+	delete(keys, key)
+
+	if len(keys) == 0 {
+		delete(idx.keys, value)
+	}
+}
+
+// LookupByIndex - returns sorted keys of items indexed by value in index name (see WithIndex),
+// expired items not removed by GC yet are included. Unknown index returns nil.
+func (c *SafeDbMapCache) LookupByIndex(name, value string) []string {
+	c.RLock()
+
+	idx, found := c.indexes[name]
+	if !found {
+		c.RUnlock()
+
+		return nil
+	}
+
+	keys := make([]string, 0, len(idx.keys[value]))
+	for k := range idx.keys[value] {
+		keys = append(keys, k)
+	}
+
+	c.RUnlock()
+
+	keys = c.displayKeys(keys)
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,132 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Background keepalive pings ///////////
+
+const (
+	// defaultKeepAlivePingTimeout - per-ping timeout of keepalive loop
+	defaultKeepAlivePingTimeout = 5 * time.Second
+
+	// defaultKeepAliveFailThreshold - number of consecutive failed pings before item eviction
+	defaultKeepAliveFailThreshold = 3
+)
+
+// keepAliveLoop - periodically pings all live items until Shutdown
+func (c *SafeDbMapCache) keepAliveLoop() {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(c.keepAlive):
+		}
+
+		c.keepAliveCycle()
+	}
+}
+
+// pingTarget - live item connection to ping
+type pingTarget struct {
+	key string
+	db  *sqlx.DB
+}
+
+// liveTargets - returns connections of all not expired items
+func (c *SafeDbMapCache) liveTargets() []pingTarget {
+	now := c.now().UnixNano()
+
+	c.RLock()
+	defer c.RUnlock()
+
+	targets := make([]pingTarget, 0, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		if deadline := i.deadline(); deadline > 0 && now > deadline {
+			return
+		}
+
+		targets = append(targets, pingTarget{key: k, db: i.Db})
+	})
+
+	return targets
+}
+
+// keepAliveCycle - pings all live items once, evicts items failed too many times
+func (c *SafeDbMapCache) keepAliveCycle() {
+	targets := c.liveTargets()
+
+	timeout := defaultKeepAlivePingTimeout
+	if c.keepAlive < timeout {
+		timeout = c.keepAlive
+	}
+
+	for _, t := range targets {
+		err := c.checkHealth(t, timeout)
+		if err != nil {
+			Logger.Warningf("db connection of key %s keepalive ping error: %s", c.redact(t.key), err.Error())
+		}
+
+		if !c.registerPing(t.key, t.db, err == nil) {
+			continue
+		}
+
+		// registered item - reconnect (repeated on next cycles if failed)
+		if c.connectFunc(t.key, t.db) != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			_, _ = c.reconnect(ctx, t.key, t.db, ReasonReconnected)
+			cancel()
+
+			continue
+		}
+
+		c.evictIfSame(t.key, t.db, ReasonKeepAliveFailed)
+	}
+}
+
+// registerPing - updates item failed pings counter, returns true if item should be evicted
+func (c *SafeDbMapCache) registerPing(key string, db *sqlx.DB, ok bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool.get(key)
+	if !found || item.Db != db {
+		return false
+	}
+
+	if ok {
+		item.pingFailures = 0
+	} else {
+		item.pingFailures++
+	}
+
+	c.pool.set(key, item)
+
+	return item.pingFailures >= c.keepAliveThreshold
+}
+
+// gcHealthCheck - pings all live items, evicts dead ones (see WithHealthCheckOnGC).
+// Returns keys of evicted items.
+func (c *SafeDbMapCache) gcHealthCheck() []string {
+	var evicted []string
+
+	for _, t := range c.liveTargets() {
+		err := c.checkHealth(t, c.healthCheckTimeout)
+		if err == nil {
+			continue
+		}
+
+		Logger.Warningf("db connection of key %s health check error: %s", c.redact(t.key), err.Error())
+
+		if c.evictIfSame(t.key, t.db, ReasonPingFailed) {
+			evicted = append(evicted, t.key)
+		}
+	}
+
+	return evicted
+}
@@ -0,0 +1,220 @@
+package dbpool
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Cache with generic (structured) keys ///////////
+
+// KeyedOptions - SafeDbMapCacheK settings
+type KeyedOptions[K comparable] struct {
+	// FormatKey - key representation in logs, events and dumps (fmt %v by default).
+	// Set it if %v of key may leak secrets.
+	FormatKey func(key K) string
+
+	// OnEvict - eviction callback with original key (replaces WithOnEvict of underlying cache)
+	OnEvict func(key K, item PoolItem, reason EvictReason)
+}
+
+// SafeDbMapCacheK - SafeDbMapCache with comparable (e.g. struct) keys, so structured keys
+// don't need string encoding. Keys are mapped to internal string keys of underlying cache
+// assigned on Set, so keys are told apart by == exactly like map keys.
+type SafeDbMapCacheK[K comparable] struct {
+	cache *SafeDbMapCache
+
+	formatKey func(key K) string
+
+	// original key <-> internal key, number of Set calls in progress by internal key
+	// (their keys aren't forgotten) and the last assigned internal key number
+	mu      sync.RWMutex
+	ids     map[K]string
+	keys    map[string]K
+	setting map[string]int
+	seq     uint64
+}
+
+// NewK - initializing a new SafeDbMapCacheK cache (see New)
+func NewK[K comparable](defaultExpiration, cleanupInterval time.Duration, kopts KeyedOptions[K],
+	opts ...Option) *SafeDbMapCacheK[K] {
+
+	c := &SafeDbMapCacheK[K]{
+		formatKey: kopts.FormatKey,
+		ids:       make(map[K]string),
+		keys:      make(map[string]K),
+		setting:   make(map[string]int),
+	}
+
+	if c.formatKey == nil {
+		c.formatKey = func(key K) string {
+			return fmt.Sprint(key)
+		}
+	}
+
+	opts = append(opts,
+		WithKeyRedactor(c.displayKey),
+		func(cache *SafeDbMapCache) {
+			cache.onRemoved = c.forget
+		},
+	)
+
+	if kopts.OnEvict != nil {
+		opts = append(opts, WithOnEvict(func(id string, item PoolItem, reason EvictReason) {
+			if key, ok := c.lookup(id); ok {
+				kopts.OnEvict(key, item, reason)
+			}
+		}))
+	}
+
+	c.cache = New(defaultExpiration, cleanupInterval, opts...)
+
+	return c
+}
+
+// Cache - returns underlying string-keyed cache (Stats, Report, etc.), its keys are internal
+func (c *SafeDbMapCacheK[K]) Cache() *SafeDbMapCache {
+	return c.cache
+}
+
+// Set - setting *sqlx.DB value by key
+func (c *SafeDbMapCacheK[K]) Set(key K, value *sqlx.DB, duration time.Duration) {
+	c.SetWithOptions(key, value, duration, SetOptions{})
+}
+
+// SetWithOptions - setting *sqlx.DB value by key with additional parameters
+func (c *SafeDbMapCacheK[K]) SetWithOptions(key K, value *sqlx.DB, duration time.Duration, opts SetOptions) {
+	// key is kept during set (for logs and events of set itself), since concurrent
+	// removal of previous item would forget it meanwhile
+	id := c.acquire(key)
+	c.cache.SetWithOptions(id, value, duration, opts)
+	c.release(id)
+}
+
+// acquire - returns internal key of key (assigns new one to unknown key) kept until release
+func (c *SafeDbMapCacheK[K]) acquire(key K) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, ok := c.ids[key]
+	if !ok {
+		c.seq++
+		id = strconv.FormatUint(c.seq, 10)
+
+		c.ids[key] = id
+		c.keys[id] = key
+	}
+
+	c.setting[id]++
+
+	return id
+}
+
+// release - ends Set of internal key, forgets key if item isn't stored (e.g. rejected Set)
+func (c *SafeDbMapCacheK[K]) release(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.setting[id]--; c.setting[id] > 0 {
+		return
+	}
+
+	delete(c.setting, id)
+
+	if !c.cache.has(id) {
+		delete(c.ids, c.keys[id])
+		delete(c.keys, id)
+	}
+}
+
+// internalKey - returns internal key of key, empty key (never stored) if key is unknown
+func (c *SafeDbMapCacheK[K]) internalKey(key K) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ids[key]
+}
+
+// Get - getting *sqlx.DB value by key (extends item expiration)
+func (c *SafeDbMapCacheK[K]) Get(key K) (*sqlx.DB, bool) {
+	return c.cache.Get(c.internalKey(key))
+}
+
+// Peek - getting *sqlx.DB value by key without extending item expiration
+func (c *SafeDbMapCacheK[K]) Peek(key K) (*sqlx.DB, bool) {
+	return c.cache.Peek(c.internalKey(key))
+}
+
+// Delete - delete *sqlx.DB value by key (see SafeDbMapCache.Delete)
+func (c *SafeDbMapCacheK[K]) Delete(key K) error {
+	return c.cache.Delete(c.internalKey(key))
+}
+
+// GetItems - returns item keys
+func (c *SafeDbMapCacheK[K]) GetItems() []K {
+	return c.lookupAll(c.cache.GetItems())
+}
+
+// ExpiredKeys - returns expired keys (see SafeDbMapCache.ExpiredKeys)
+func (c *SafeDbMapCacheK[K]) ExpiredKeys() []K {
+	return c.lookupAll(c.cache.ExpiredKeys())
+}
+
+// DeleteExpired - removes all expired items, returns number of removed items
+func (c *SafeDbMapCacheK[K]) DeleteExpired() int {
+	return c.cache.DeleteExpired()
+}
+
+// Shutdown - stops Garbage Collection and removes all items
+func (c *SafeDbMapCacheK[K]) Shutdown() {
+	c.cache.Shutdown()
+}
+
+// lookup - returns original key of internal key
+func (c *SafeDbMapCacheK[K]) lookup(id string) (K, bool) {
+	c.mu.RLock()
+	key, ok := c.keys[id]
+	c.mu.RUnlock()
+
+	return key, ok
+}
+
+// lookupAll - returns original keys of internal keys (keys being set right now are skipped)
+func (c *SafeDbMapCacheK[K]) lookupAll(ids []string) []K {
+	keys := make([]K, 0, len(ids))
+	for _, id := range ids {
+		if key, ok := c.lookup(id); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// displayKey - returns formatted original key of internal key (underlying cache key redactor)
+func (c *SafeDbMapCacheK[K]) displayKey(id string) string {
+	key, ok := c.lookup(id)
+	if !ok {
+		return "-"
+	}
+
+	return c.formatKey(key)
+}
+
+// forget - forgets original key of removed item (if it wasn't set again and isn't being set)
+func (c *SafeDbMapCacheK[K]) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.setting[id] > 0 || c.cache.has(id) {
+		return
+	}
+
+	if key, ok := c.keys[id]; ok {
+		delete(c.ids, key)
+		delete(c.keys, id)
+	}
+}
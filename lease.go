@@ -0,0 +1,113 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Connection leases ///////////
+
+// lease - getting *sqlx.DB by key like Get and holding it open: connection of item removed
+// meanwhile is closed by unlease. Returns ErrKeyNotFound on miss (or ErrCircuitOpen, see WithCircuitBreaker).
+func (c *SafeDbMapCache) lease(key string) (*sqlx.DB, error) {
+	hk := c.hashKey(key)
+
+	db, found, err := c.getWith(hk, GetOptions{Touch: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// item was replaced or removed right after read
+	if item, found := c.pool.get(hk); !found || !item.owns(db) {
+		return nil, ErrKeyNotFound
+	}
+
+	c.refs[db]++
+	c.leases[db]++
+	c.leaseKeys[db] = hk
+
+	return db, nil
+}
+
+// unlease - drops lease of key connection, closes it if it was removed from cache during lease.
+// Lease dropped by Shutdown is ignored: its connection is already closed.
+func (c *SafeDbMapCache) unlease(key string, db *sqlx.DB) {
+	c.Lock()
+
+	if c.leases[db] <= 0 {
+		c.Unlock()
+
+		return
+	}
+
+	c.unref(db)
+	removed := c.refs[db] == 0
+
+	c.leases[db]--
+	if c.leases[db] <= 0 {
+		delete(c.leases, db)
+		delete(c.leaseKeys, db)
+	}
+
+	c.Unlock()
+
+	if !removed {
+		return
+	}
+
+	key = c.hashKey(key)
+
+	err := c.closeDb(key, db)
+	if err != nil {
+		Logger.Warningf("db connection of key %s close error: %s", c.redact(key), err.Error())
+	}
+}
+
+// Lease - getting *sqlx.DB value by key like Get and holding it open until release is called:
+// connection of item removed meanwhile (Delete, GC, etc.) is closed by release, Shutdown
+// closes it immediately. Release can be called more than once.
+func (c *SafeDbMapCache) Lease(key string) (*sqlx.DB, func(), bool) {
+	db, err := c.lease(key)
+	if err != nil {
+		return nil, func() {}, false
+	}
+
+	var once sync.Once
+
+	return db, func() { once.Do(func() { c.unlease(key, db) }) }, true
+}
+
+// closeLeased - closes connections of removed items still held by leases (see Shutdown)
+func (c *SafeDbMapCache) closeLeased() {
+	c.Lock()
+
+	var leased []pingTarget
+	for db := range c.refs {
+		leased = append(leased, pingTarget{key: c.leaseKeys[db], db: db})
+
+		// leases are dropped, so later release doesn't close connection once more
+		delete(c.refs, db)
+		delete(c.leases, db)
+		delete(c.leaseKeys, db)
+		c.release(db)
+	}
+
+	c.Unlock()
+
+	for _, t := range leased {
+		err := c.closeDb(t.key, t.db)
+		if err != nil {
+			Logger.Warningf("db connection of key %s close error: %s", c.redact(t.key), err.Error())
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package dbpool
+
+import (
+	"sort"
+	"strings"
+)
+
+/////// Namespaced sub-pools ///////////
+
+// namespaceSeparator - separator between namespace and key (see NamespaceKey)
+const namespaceSeparator = "\x00"
+
+// NamespaceKey - returns cache key for key in namespace ns.
+// Result can be used with all cache methods, namespace must not contain "\x00".
+func NamespaceKey(ns, key string) string {
+	return ns + namespaceSeparator + key
+}
+
+// splitNamespaceKey - returns namespace and key of namespaced cache key
+func splitNamespaceKey(fullKey string) (ns, key string, ok bool) {
+	i := strings.Index(fullKey, namespaceSeparator)
+	if i < 0 {
+		return "", fullKey, false
+	}
+
+	return fullKey[:i], fullKey[i+len(namespaceSeparator):], true
+}
+
+// indexNamespace - adds key to namespace index (must be called under write lock)
+func (c *SafeDbMapCache) indexNamespace(fullKey string) {
+	ns, _, ok := splitNamespaceKey(fullKey)
+	if !ok {
+		return
+	}
+
+	keys, found := c.namespaces[ns]
+	if !found {
+		keys = make(map[string]struct{})
+		c.namespaces[ns] = keys
+	}
+
+	keys[fullKey] = struct{}{}
+}
+
+// unindexNamespace - removes key from namespace index (must be called under write lock)
+func (c *SafeDbMapCache) unindexNamespace(fullKey string) {
+	ns, _, ok := splitNamespaceKey(fullKey)
+	if !ok {
+		return
+	}
+
+	keys := c.namespaces[ns]
+	delete(keys, fullKey)
+
+	if len(keys) == 0 {
+		delete(c.namespaces, ns)
+	}
+}
+
+// ItemsInNamespace - returns sorted keys (without namespace) of namespace ns items
+func (c *SafeDbMapCache) ItemsInNamespace(ns string) []string {
+	c.RLock()
+
+	fullKeys := make([]string, 0, len(c.namespaces[ns]))
+	for fullKey := range c.namespaces[ns] {
+		fullKeys = append(fullKeys, fullKey)
+	}
+
+	c.RUnlock()
+
+	items := make([]string, 0, len(fullKeys))
+	for _, fullKey := range c.displayKeys(fullKeys) {
+		_, key, _ := splitNamespaceKey(fullKey)
+
+		items = append(items, key)
+	}
+
+	sort.Strings(items)
+
+	return items
+}
+
+// DeleteNamespace - delete all namespace ns items.
+// Returns ErrKeyNotFound if namespace is empty and first close error if any.
+func (c *SafeDbMapCache) DeleteNamespace(ns string) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	c.Lock()
+
+	removed := make([]removedItem, 0, len(c.namespaces[ns]))
+	for fullKey := range c.namespaces[ns] {
+		removed = append(removed, removedItem{key: fullKey, item: c.pool.item(fullKey), reason: ReasonDeleted})
+
+		c.deleteItem(fullKey)
+	}
+
+	c.Unlock()
+
+	if len(removed) == 0 {
+		return ErrKeyNotFound
+	}
+
+	var firstErr error
+	for _, r := range removed {
+		err := c.closeItem(r)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
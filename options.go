@@ -0,0 +1,454 @@
+package dbpool
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// SafeDbMapCache options ///////////
+
+// Option - SafeDbMapCache configuration option (see New)
+type Option func(*SafeDbMapCache)
+
+// WithAdaptiveGC - enables adaptive GC interval.
+// GC shortens its interval (down to minInterval) when previous cycle evicted many items
+// or the pool is large, and lengthens it (up to maxInterval) when nothing is expiring.
+func WithAdaptiveGC(minInterval, maxInterval time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		if minInterval <= 0 || maxInterval < minInterval {
+			return
+		}
+
+		if minInterval < MinCleanupInterval {
+			minInterval = MinCleanupInterval
+		}
+
+		if maxInterval < minInterval {
+			maxInterval = minInterval
+		}
+
+		c.adaptiveGC = true
+		c.minCleanupInterval = minInterval
+		c.maxCleanupInterval = maxInterval
+	}
+}
+
+// WithOnEvict - sets callback called after item connection is closed on every removal
+// (Delete, GC, ClearAll, failed ping, etc. - see EvictReason). Callback is called without cache lock.
+func WithOnEvict(onEvict func(key string, item PoolItem, reason EvictReason)) Option {
+	return func(c *SafeDbMapCache) {
+		c.onEvict = onEvict
+	}
+}
+
+// WithServeStale - enables serve-stale mode: expired item is still returned by GetStale
+// (flagged as stale) for hardExpiry after expiration, meanwhile refresh creates new connection in background.
+func WithServeStale(hardExpiry time.Duration, refresh RefreshFunc) Option {
+	return func(c *SafeDbMapCache) {
+		if hardExpiry <= 0 || refresh == nil {
+			return
+		}
+
+		c.staleTTL = hardExpiry
+		c.refresh = refresh
+	}
+}
+
+// WithEvictOnlyIdle - GC skips expired items whose connections are in use (Db.Stats().InUse > 0),
+// they are evicted on one of the next GC cycles.
+func WithEvictOnlyIdle(enabled bool) Option {
+	return func(c *SafeDbMapCache) {
+		c.evictOnlyIdle = enabled
+	}
+}
+
+// WithMaxEvictDeferrals - max number of deferred GC evictions for busy item (see WithEvictOnlyIdle),
+// after that item is force closed. Zero means no limit.
+func WithMaxEvictDeferrals(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n < 0 {
+			return
+		}
+
+		c.maxEvictDeferrals = n
+	}
+}
+
+// WithClock - sets clock used for expiration math (monotonic-based clock by default), useful for tests
+func WithClock(now func() time.Time) Option {
+	return func(c *SafeDbMapCache) {
+		if now == nil {
+			return
+		}
+
+		c.now = now
+	}
+}
+
+// WithCloseDelay - GC removes evicted items from cache immediately, but closes
+// their connections after delay (grace period for queries started right before eviction).
+// Pending connections are closed on Shutdown.
+func WithCloseDelay(delay time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.closeDelay = delay
+	}
+}
+
+// WithKeepAlive - enables background loop pinging every live item with interval
+// (prevents server-side idle disconnects). Loop is stopped by Shutdown.
+func WithKeepAlive(interval time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.keepAlive = interval
+	}
+}
+
+// WithKeepAliveFailThreshold - number of consecutive failed keepalive pings
+// after which item is closed and evicted (3 by default)
+func WithKeepAliveFailThreshold(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.keepAliveThreshold = n
+	}
+}
+
+// WithReconnectBackoff - sets delays between failed reconnect attempts of one key
+// (exponentially growing from minBackoff to maxBackoff)
+func WithReconnectBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		if minBackoff <= 0 || maxBackoff < minBackoff {
+			return
+		}
+
+		c.reconnectMinBackoff = minBackoff
+		c.reconnectMaxBackoff = maxBackoff
+	}
+}
+
+// WithMaxLifetime - GC closes connections living longer than maxLifetime since first creation
+// (PoolItem.FirstCreated) regardless of TTL and access pattern. Can be overridden per item
+// (SetOptions.MaxAge), items with connect func are rotated instead of removal.
+func WithMaxLifetime(maxLifetime time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.maxLifetime = maxLifetime
+	}
+}
+
+// WithHealthCheckOnGC - GC pings every live item (each ping is limited by pingTimeout)
+// and evicts dead ones, so pool is kept clean even for rarely accessed keys
+func WithHealthCheckOnGC(enabled bool, pingTimeout time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		if pingTimeout <= 0 {
+			pingTimeout = defaultKeepAlivePingTimeout
+		}
+
+		c.healthCheckOnGC = enabled
+		c.healthCheckTimeout = pingTimeout
+	}
+}
+
+// WithEventBuffer - sets Events channel buffer size (128 by default).
+// Events are dropped when buffer is full (see Stats.DroppedEvents).
+func WithEventBuffer(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n < 0 {
+			return
+		}
+
+		c.eventBuffer = n
+	}
+}
+
+// WithHooks - sets operation hooks (see Hooks)
+func WithHooks(hooks Hooks) Option {
+	return func(c *SafeDbMapCache) {
+		c.hooks = &hooks
+	}
+}
+
+// WithCloseGuard - Get and GC detect connections closed outside of cache (by caller),
+// log and evict them instead of returning closed connection. Check makes no network round trip.
+func WithCloseGuard(enabled bool) Option {
+	return func(c *SafeDbMapCache) {
+		c.closeGuard = enabled
+	}
+}
+
+// WithHistory - records last n pool operations (set, get miss, delete, eviction),
+// see History. Disabled by default.
+func WithHistory(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.history = newHistory(n)
+	}
+}
+
+// WithKeyRedactor - sets function hiding sensitive parts of keys (DSN passwords, tenant names)
+// in logs, returned errors, Events, History, Report, DumpState and DebugHandler.
+// RedactDSN is used by default, nil disables redaction. Redactor is called without cache lock.
+func WithKeyRedactor(redact func(key string) string) Option {
+	return func(c *SafeDbMapCache) {
+		c.redactKey = redact
+	}
+}
+
+// WithTracer - traces connection opens and pings (see Tracer)
+func WithTracer(tracer Tracer) Option {
+	return func(c *SafeDbMapCache) {
+		c.tracer = tracer
+	}
+}
+
+// WithEvictionPolicy - sets additional GC eviction rule (see EvictionPolicy, LRUPolicy, LFUPolicy)
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(c *SafeDbMapCache) {
+		c.policy = policy
+	}
+}
+
+// WithHashedKeys - keys are hashed with hash (SHA256Key if nil) on every operation, so raw keys
+// (e.g. DSNs with passwords) never appear as map keys. With keepDisplay redacted display form
+// of key (see WithKeyRedactor) is remembered and returned by GetItems, ItemsInfo, Keys, etc. and
+// used in logs and dumps, otherwise hashed keys are returned. Callbacks (WithOnEvict, Hooks,
+// EvictionPolicy, DeleteFunc) receive hashed keys.
+func WithHashedKeys(hash func(key string) string, keepDisplay bool) Option {
+	return func(c *SafeDbMapCache) {
+		if hash == nil {
+			hash = SHA256Key
+		}
+
+		c.hashKeys = hash
+		c.keepDisplay = keepDisplay
+	}
+}
+
+// WithConnSettings - sets connection settings applied to every stored connection (Set, RegisterDSN dial,
+// reconnect, refresh), SetOptions.ConnSettings overrides them for single item
+func WithConnSettings(settings ConnSettings) Option {
+	return func(c *SafeDbMapCache) {
+		c.connSettings = settings
+	}
+}
+
+// WithConnBudget - limits sum of MaxOpenConns of all stored connections by n (connection without
+// MaxOpenConns limit takes the whole budget, see WithConnSettings). Reconnected and refreshed
+// connections replace charge of old ones without check. Policy selects behavior of exhausted budget.
+func WithConnBudget(n int, policy BudgetPolicy) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.budget = n
+		c.budgetPolicy = policy
+	}
+}
+
+// WithMaxItems - limits number of pool items by n, policy selects behavior of Set and GetOrCreate
+// of new key when pool is full: evict least recently used item or fail with ErrPoolFull
+func WithMaxItems(n int, policy FullPolicy) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.maxItems = n
+		c.fullPolicy = policy
+	}
+}
+
+// WithCloseFunc - sets function closing connections removed from cache (GC, Delete, ClearAll, etc.)
+// instead of Db.Close, e.g. for two-step shutdown with context or connection drain
+func WithCloseFunc(closeFunc func(db *sqlx.DB) error) Option {
+	return func(c *SafeDbMapCache) {
+		c.closeFunc = closeFunc
+	}
+}
+
+// WithStmtCacheSize - sets max number of prepared statements cached per connection (32 by default),
+// least recently used statement is closed (see PreparedQueryx)
+func WithStmtCacheSize(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.stmtCacheSize = n
+	}
+}
+
+// WithCircuitBreaker - enables circuit breaker per key: after threshold consecutive connection-level
+// failures (reported by query helpers or ReportFailure) Get-like reads of key fast-fail (GetWith,
+// GetVerified and query helpers return ErrCircuitOpen) for coolDown, then single probe is allowed.
+// Successful probe closes breaker, failed one opens it again.
+func WithCircuitBreaker(threshold int, coolDown time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		if threshold <= 0 || coolDown <= 0 {
+			return
+		}
+
+		c.breakers = &breakers{
+			threshold: threshold,
+			coolDown:  coolDown,
+			byKey:     make(map[string]*breaker),
+		}
+	}
+}
+
+// WithDialFailureTTL - remembers dial failure of registered key (see RegisterDSN) for ttl:
+// meanwhile GetOrConnect returns it immediately (wrapped) without dialing. Failure is forgotten
+// by successful Set of key or by ClearFailure, ttl doesn't depend on item TTL.
+func WithDialFailureTTL(ttl time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.dialFailureTTL = ttl
+	}
+}
+
+// WithDialRateLimit - limits new connection dials of every key (GetOrConnect, reconnect, rotation)
+// by n per period (token bucket), exceeding dials fail with ErrDialRateLimited.
+// Can be overridden per registration (see WithDialRate). Limiter state is dropped by Delete and Unregister.
+func WithDialRateLimit(n int, period time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.dialRate = dialRate{n: n, period: period}
+	}
+}
+
+// WithMaxConcurrentDials - limits number of connection dials running at once in the whole pool by n
+// (GetOrCreate, GetOrConnect, Warmup, reconnect, stale refresh). Other dials wait for free slot,
+// waiting dial returns ctx.Err() as soon as its context is done (see Stats.DialsQueued).
+func WithMaxConcurrentDials(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.dialSlots = make(chan struct{}, n)
+	}
+}
+
+// WithHealthCheckRetry - health checks (see WithHealthCheckOnGC, WithKeepAlive) retry failed ping
+// with exponential backoff and consider connection dead only after retry.Attempts failed pings.
+// Item stays servable between attempts unless retry.Strict is set. Attempts of the last check
+// are reported by ItemsInfo and GetItem. Retries delay check of the next items.
+func WithHealthCheckRetry(retry HealthRetry) Option {
+	return func(c *SafeDbMapCache) {
+		if retry.Attempts <= 0 || retry.MinBackoff < 0 || retry.MaxBackoff < retry.MinBackoff {
+			return
+		}
+
+		c.healthRetry = retry
+	}
+}
+
+// WithHealthCheck - enables background loop pinging every live item with interval and applying
+// onFail policy to items failed ping (see FailPolicy, WithHealthCheckRetry). Loop is stopped by Shutdown.
+func WithHealthCheck(interval time.Duration, onFail FailPolicy) Option {
+	return func(c *SafeDbMapCache) {
+		c.healthInterval = interval
+		c.healthFail = onFail
+	}
+}
+
+// WithConnectRetry - failed connection opening of GetOrCreate factory and registered key dial
+// (GetOrConnect, Warmup) is retried with backoff up to policy.MaxAttempts attempts.
+// Waiting for next attempt is interrupted by caller context (registered key dial is bounded by
+// 30s timeout instead, its callers stop waiting on their context). Reconnect uses its own backoff
+// (see WithReconnectBackoff).
+func WithConnectRetry(policy RetryPolicy) Option {
+	return func(c *SafeDbMapCache) {
+		c.connectRetryPolicy = policy
+	}
+}
+
+// WithEvictionLog - records last n item removals (key, reason, time) of any kind, see RecentEvictions.
+// Disabled by default.
+func WithEvictionLog(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.evictionLog = newHistory(n)
+	}
+}
+
+// WithBeforeClose - sets hook called synchronously right before pool closes connection of key
+// on any path (GC, Delete, ClearAll, replacement, reconnect, etc.), e.g. to release application
+// resources bound to connection. Unlike WithOnEvict callback, it runs before close and for
+// connections never stored too. Hook is called without cache lock, its panic is recovered and logged.
+func WithBeforeClose(beforeClose func(key string, db *sqlx.DB)) Option {
+	return func(c *SafeDbMapCache) {
+		c.beforeClose = beforeClose
+	}
+}
+
+// WithIndex - maintains secondary index name of items by value extract returns (e.g. host from metadata),
+// see LookupByIndex. Empty value isn't indexed. Index is updated on every item insert and removal
+// (Set, GC, Delete, reconnect, etc.), extract is called under cache write lock, so it must not call
+// cache methods.
+func WithIndex(name string, extract func(item PoolItem) string) Option {
+	return func(c *SafeDbMapCache) {
+		if extract == nil {
+			return
+		}
+
+		if c.indexes == nil {
+			c.indexes = make(map[string]*secondaryIndex)
+		}
+
+		c.indexes[name] = &secondaryIndex{
+			extract: extract,
+			keys:    make(map[string]map[string]struct{}),
+			values:  make(map[string]string),
+		}
+	}
+}
+
+// WithMinEntries - GC doesn't remove n most recently used items on TTL expiration, so quiet pool
+// keeps warm connections: their deadlines are renewed by GC sweep instead (see SetOptions.Pinned).
+// Kept items are still removed by Delete, max age and eviction policy. Disabled by default.
+func WithMinEntries(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.minEntries = n
+	}
+}
+
+// WithFailback - enables background loop probing preferred DSNs of registrations failed over
+// to standby ones (see WithFailoverDSNs) with interval: connection of key is replaced by connection
+// to reachable preferred DSN. Without it key returns to preferred DSN on the next dial or reconnect only.
+func WithFailback(interval time.Duration) Option {
+	return func(c *SafeDbMapCache) {
+		c.failbackInterval = interval
+	}
+}
+
+// WithGCRepanic - re-raises GC sweep panic after logging (strict mode for tests) instead of
+// recovering it and continuing GC loop with the next sweep (see Stats.PanicCount)
+func WithGCRepanic(repanic bool) Option {
+	return func(c *SafeDbMapCache) {
+		c.gcRepanic = repanic
+	}
+}
+
+// WithShards - splits pool items into n shards by key hash (16 by default): Get-like reads
+// lock shard of their key only, so reads of unrelated keys don't contend. Set, Delete, GC
+// and other changes still take cache-wide lock: refs, budget and namespaces span shards.
+func WithShards(n int) Option {
+	return func(c *SafeDbMapCache) {
+		if n <= 0 {
+			return
+		}
+
+		c.shards = n
+	}
+}
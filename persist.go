@@ -0,0 +1,126 @@
+package dbpool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+/////// Registrations persistence (rewarm after restart) ///////////
+
+// SavedRegistration - serialized connection registration (see SaveRegistrations)
+type SavedRegistration struct {
+	Key      string            `json:"key"`
+	Driver   string            `json:"driver"`
+	DSN      string            `json:"dsn"` // sealed with seal func of SaveRegistrations
+	TTL      time.Duration     `json:"ttl"`
+	MaxAge   time.Duration     `json:"max_age,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Standby  []string          `json:"standby,omitempty"` // sealed like DSN (see WithFailoverDSNs)
+
+	// dial rate limit of registration (see WithDialRate), zero - not limited
+	DialRate       int           `json:"dial_rate,omitempty"`
+	DialRatePeriod time.Duration `json:"dial_rate_period,omitempty"`
+}
+
+// SaveRegistrations - writes registered connection parameters (see RegisterDSN) as json sorted by key.
+// DSNs (standby ones too) are passed through seal (encryption or replacing with external secret reference),
+// nil seal writes DSNs as is. Setup functions and opened connections are not saved.
+func (c *SafeDbMapCache) SaveRegistrations(w io.Writer, seal func(dsn string) (string, error)) error {
+	c.registryMu.Lock()
+
+	saved := make([]SavedRegistration, 0, len(c.registry))
+	for k, reg := range c.registry {
+		saved = append(saved, SavedRegistration{
+			Key:      k,
+			Driver:   reg.driver,
+			DSN:      reg.dsn,
+			TTL:      reg.ttl,
+			MaxAge:   reg.maxAge,
+			Metadata: copyMetadata(reg.metadata),
+			Standby:  append([]string(nil), reg.standby...),
+
+			DialRate:       reg.dialRate.n,
+			DialRatePeriod: reg.dialRate.period,
+		})
+	}
+
+	c.registryMu.Unlock()
+
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].Key < saved[j].Key
+	})
+
+	if seal != nil {
+		for i := range saved {
+			dsn, err := seal(saved[i].DSN)
+			if err != nil {
+				return fmt.Errorf("dbpool: seal dsn of %s: %w", c.redact(saved[i].Key), err)
+			}
+
+			saved[i].DSN = dsn
+
+			for j, standby := range saved[i].Standby {
+				if saved[i].Standby[j], err = seal(standby); err != nil {
+					return fmt.Errorf("dbpool: seal standby dsn of %s: %w", c.redact(saved[i].Key), err)
+				}
+			}
+		}
+	}
+
+	return json.NewEncoder(w).Encode(saved)
+}
+
+// LoadRegistrations - registers connection parameters written by SaveRegistrations,
+// DSNs are passed through open (reverse of seal, nil - DSNs are used as is) and opts
+// (e.g. WithSetup) are applied to every registration. Already registered keys are kept
+// untouched, so loading merges into non-empty pool. Returns keys of loaded registrations,
+// call Warmup to dial them immediately.
+func (c *SafeDbMapCache) LoadRegistrations(r io.Reader, open func(sealed string) (string, error),
+	opts ...RegisterOption) ([]string, error) {
+
+	var saved []SavedRegistration
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("dbpool: decode registrations: %w", err)
+	}
+
+	var loaded []string
+	for _, s := range saved {
+		c.registryMu.Lock()
+		_, found := c.registry[s.Key]
+		c.registryMu.Unlock()
+
+		if found {
+			continue
+		}
+
+		dsn := s.DSN
+		standby := append([]string(nil), s.Standby...)
+		if open != nil {
+			var err error
+			if dsn, err = open(s.DSN); err != nil {
+				return loaded, fmt.Errorf("dbpool: open dsn of %s: %w", c.redact(s.Key), err)
+			}
+
+			for i := range standby {
+				if standby[i], err = open(standby[i]); err != nil {
+					return loaded, fmt.Errorf("dbpool: open standby dsn of %s: %w", c.redact(s.Key), err)
+				}
+			}
+		}
+
+		regOpts := []RegisterOption{WithMetadata(s.Metadata), WithMaxAge(s.MaxAge), WithFailoverDSNs(standby...)}
+		if s.DialRate > 0 {
+			regOpts = append(regOpts, WithDialRate(s.DialRate, s.DialRatePeriod))
+		}
+
+		regOpts = append(regOpts, opts...)
+		c.RegisterDSN(s.Key, s.Driver, dsn, s.TTL, regOpts...)
+
+		loaded = append(loaded, s.Key)
+	}
+
+	return loaded, nil
+}
@@ -0,0 +1,101 @@
+package dbpool
+
+import (
+	"sync"
+	"time"
+)
+
+/////// Pluggable eviction policies ///////////
+
+// EvictionPolicy - additional GC eviction rule (see WithEvictionPolicy). Items are evicted
+// when their TTL is expired or policy says so. ShouldEvict is called by GC (and by
+// ExpiredKeys, Keys) under cache lock, so it must be fast and must not call cache methods.
+// OnAccess is called on every successful Get-like read.
+// Policy may also implement OnRemove(key string) to forget removed items.
+type EvictionPolicy interface {
+	ShouldEvict(key string, item PoolItem, now time.Time) bool
+	OnAccess(key string)
+}
+
+// removeNotifier - optional EvictionPolicy interface
+type removeNotifier interface {
+	OnRemove(key string)
+}
+
+// TTLPolicy - evicts items with expired TTL (default cache behavior, useful for composition)
+type TTLPolicy struct{}
+
+// ShouldEvict - returns true if nearer of item deadlines has passed
+func (TTLPolicy) ShouldEvict(key string, item PoolItem, now time.Time) bool {
+	deadline := item.deadline()
+
+	return deadline > 0 && now.UnixNano() > deadline
+}
+
+// OnAccess - does nothing
+func (TTLPolicy) OnAccess(key string) {}
+
+// LRUPolicy - evicts items not accessed for MaxIdle regardless of their TTL
+type LRUPolicy struct {
+	MaxIdle time.Duration
+}
+
+// ShouldEvict - returns true if item was last accessed more than MaxIdle ago
+func (p LRUPolicy) ShouldEvict(key string, item PoolItem, now time.Time) bool {
+	return p.MaxIdle > 0 && now.Sub(item.Created) > p.MaxIdle
+}
+
+// OnAccess - does nothing (last access time is kept in PoolItem.Created)
+func (LRUPolicy) OnAccess(key string) {}
+
+// LFUPolicy - evicts rarely used items: accessed less than minHits times per period
+// on average since creation (items younger than period are kept)
+type LFUPolicy struct {
+	minHits int
+	period  time.Duration
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+// NewLFUPolicy - initializing a new LFUPolicy
+func NewLFUPolicy(minHits int, period time.Duration) *LFUPolicy {
+	return &LFUPolicy{
+		minHits: minHits,
+		period:  period,
+		hits:    make(map[string]int),
+	}
+}
+
+// ShouldEvict - returns true if item average access rate is lower than minHits per period
+func (p *LFUPolicy) ShouldEvict(key string, item PoolItem, now time.Time) bool {
+	age := now.Sub(item.FirstCreated)
+	if p.period <= 0 || age < p.period {
+		return false
+	}
+
+	p.mu.Lock()
+	hits := p.hits[key]
+	p.mu.Unlock()
+
+	return float64(hits) < float64(p.minHits)*float64(age)/float64(p.period)
+}
+
+// OnAccess - counts item access
+func (p *LFUPolicy) OnAccess(key string) {
+	p.mu.Lock()
+	p.hits[key]++
+	p.mu.Unlock()
+}
+
+// OnRemove - forgets removed item accesses
+func (p *LFUPolicy) OnRemove(key string) {
+	p.mu.Lock()
+	delete(p.hits, key)
+	p.mu.Unlock()
+}
+
+// policyEvicts - returns true if eviction policy (if set) says item should be evicted
+func (c *SafeDbMapCache) policyEvicts(key string, item PoolItem) bool {
+	return c.policy != nil && c.policy.ShouldEvict(key, item.synced(), c.now())
+}
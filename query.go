@@ -0,0 +1,194 @@
+package dbpool
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Query helpers resolving connection by key ///////////
+
+// conn - getting *sqlx.DB by key like Get (extends item expiration, counts hit or miss),
+// returns ErrKeyNotFound on miss and ErrCircuitOpen if key breaker is open (see WithCircuitBreaker)
+func (c *SafeDbMapCache) conn(key string) (*sqlx.DB, error) {
+	db, found, err := c.getWith(c.hashKey(key), GetOptions{Touch: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	return db, nil
+}
+
+// QueryxContext - runs query with connection of key (see sqlx.DB.QueryxContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) QueryxContext(ctx context.Context, key, query string, args ...interface{}) (*sqlx.Rows, error) {
+	db, err := c.conn(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryxContext(ctx, query, args...)
+
+	return rows, c.observe(key, err)
+}
+
+// GetContext - scans single row of query into dest with connection of key (see sqlx.DB.GetContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) GetContext(ctx context.Context, key string, dest interface{}, query string, args ...interface{}) error {
+	db, err := c.conn(key)
+	if err != nil {
+		return err
+	}
+
+	return c.observe(key, db.GetContext(ctx, dest, query, args...))
+}
+
+// SelectContext - scans all rows of query into dest with connection of key (see sqlx.DB.SelectContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) SelectContext(ctx context.Context, key string, dest interface{}, query string, args ...interface{}) error {
+	db, err := c.conn(key)
+	if err != nil {
+		return err
+	}
+
+	return c.observe(key, db.SelectContext(ctx, dest, query, args...))
+}
+
+// ExecContext - executes query with connection of key (see sqlx.DB.ExecContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) ExecContext(ctx context.Context, key, query string, args ...interface{}) (sql.Result, error) {
+	db, err := c.conn(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
+
+	return res, c.observe(key, err)
+}
+
+// NamedExecContext - executes named query with connection of key (see sqlx.DB.NamedExecContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) NamedExecContext(ctx context.Context, key, query string, arg interface{}) (sql.Result, error) {
+	db, err := c.conn(key)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.NamedExecContext(ctx, query, arg)
+
+	return res, c.observe(key, err)
+}
+
+// NamedQueryContext - runs named query with connection of key (see sqlx.DB.NamedQueryContext).
+// Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) NamedQueryContext(ctx context.Context, key, query string, arg interface{}) (*sqlx.Rows, error) {
+	db, err := c.conn(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.NamedQueryContext(ctx, query, arg)
+
+	return rows, c.observe(key, err)
+}
+
+// isConnError - returns true if err is connection-level error (dead connection),
+// not statement one (syntax, constraint violation, etc.)
+func isConnError(err error) bool {
+	var opErr *net.OpError
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &opErr)
+}
+
+// isRetryable - returns true if statement failed before it was sent to server, so it can be
+// run again safely. Other connection errors (timeouts, broken pipe, unexpected EOF) may come
+// after server executed statement.
+func isRetryable(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone)
+}
+
+// withRetry - runs statement with connection of key, on error of not sent statement (see isRetryable)
+// replaces dead connection and retries statement once on fresh one. Returns error of the last run.
+func (c *SafeDbMapCache) withRetry(ctx context.Context, key string, run func(db *sqlx.DB) error) error {
+	db, err := c.conn(key)
+	if err != nil {
+		return err
+	}
+
+	err = run(db)
+	if err == nil || !isRetryable(err) {
+		return c.observe(key, err)
+	}
+
+	fresh, connErr := c.replaceDead(ctx, key, db)
+	if connErr != nil {
+		return c.observe(key, err)
+	}
+
+	atomic.AddInt64(&c.retries, 1)
+
+	err = run(fresh)
+	if err != nil {
+		atomic.AddInt64(&c.failedRetries, 1)
+	}
+
+	return c.observe(key, err)
+}
+
+// replaceDead - returns fresh connection of key instead of dead one: item is reconnected
+// if it has connect func, otherwise it is removed and redialed if registered (see RegisterDSN)
+func (c *SafeDbMapCache) replaceDead(ctx context.Context, key string, dead *sqlx.DB) (*sqlx.DB, error) {
+	hk := c.hashKey(key)
+
+	if c.connectFunc(hk, dead) != nil {
+		return c.reconnect(ctx, hk, dead, ReasonBadConn)
+	}
+
+	c.evictIfSame(hk, dead, ReasonBadConn)
+
+	return c.GetOrConnect(ctx, key)
+}
+
+// ExecWithRetry - executes query with connection of key like ExecContext. If statement wasn't sent
+// because connection is dead (driver.ErrBadConn, sql.ErrConnDone), connection is reconnected
+// (see SetOptions.Connect), or removed and redialed (see RegisterDSN), and query is retried once.
+// Other errors aren't retried: statement could be executed by server before network failure
+// (e.g. read timeout), query must be idempotent anyway, since driver may report it unsent by mistake.
+func (c *SafeDbMapCache) ExecWithRetry(ctx context.Context, key, query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+
+	err := c.withRetry(ctx, key, func(db *sqlx.DB) (err error) {
+		res, err = db.ExecContext(ctx, query, args...)
+		return err
+	})
+
+	return res, err
+}
+
+// QueryWithRetry - runs query with connection of key like QueryxContext, retrying it once
+// on connection error (see ExecWithRetry)
+func (c *SafeDbMapCache) QueryWithRetry(ctx context.Context, key, query string, args ...interface{}) (*sqlx.Rows, error) {
+	var rows *sqlx.Rows
+
+	err := c.withRetry(ctx, key, func(db *sqlx.DB) (err error) {
+		rows, err = db.QueryxContext(ctx, query, args...)
+		return err
+	})
+
+	return rows, err
+}
@@ -0,0 +1,88 @@
+package dbpool
+
+import (
+	"sync"
+	"time"
+)
+
+/////// Per-key rate limiting of dials ///////////
+
+// dialRate - max number of dials per period
+type dialRate struct {
+	n      int
+	period time.Duration
+}
+
+// dialBucket - token bucket of key dials
+type dialBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// dialLimiter - dial token buckets and overridden rates by internal key
+type dialLimiter struct {
+	sync.Mutex
+
+	buckets map[string]*dialBucket
+	rates   map[string]dialRate
+}
+
+// allowDial - takes token from key bucket, returns false if dial rate of key is exceeded
+func (c *SafeDbMapCache) allowDial(key string) bool {
+	c.dialLimits.Lock()
+	defer c.dialLimits.Unlock()
+
+	rate, found := c.dialLimits.rates[key]
+	if !found {
+		rate = c.dialRate
+	}
+
+	if rate.n <= 0 || rate.period <= 0 {
+		return true
+	}
+
+	now := c.now()
+
+	b, found := c.dialLimits.buckets[key]
+	if !found {
+		b = &dialBucket{tokens: float64(rate.n), last: now}
+		c.dialLimits.buckets[key] = b
+	}
+
+	// refill
+	b.tokens += float64(rate.n) * float64(now.Sub(b.last)) / float64(rate.period)
+	if b.tokens > float64(rate.n) {
+		b.tokens = float64(rate.n)
+	}
+
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// setDialRate - overrides dial rate of key (zero rate - use pool one)
+func (c *SafeDbMapCache) setDialRate(key string, rate dialRate) {
+	c.dialLimits.Lock()
+	defer c.dialLimits.Unlock()
+
+	if rate.n <= 0 || rate.period <= 0 {
+		delete(c.dialLimits.rates, key)
+		return
+	}
+
+	c.dialLimits.rates[key] = rate
+}
+
+// forgetDialBucket - drops dial limiter state of key
+func (c *SafeDbMapCache) forgetDialBucket(key string) {
+	c.dialLimits.Lock()
+	defer c.dialLimits.Unlock()
+
+	delete(c.dialLimits.buckets, key)
+}
@@ -0,0 +1,101 @@
+package dbpool
+
+/////// Eviction reasons ///////////
+
+// EvictReason - reason of item removal from cache
+type EvictReason int
+
+const (
+	// ReasonDeleted - removed by Delete
+	ReasonDeleted EvictReason = iota
+
+	// ReasonExpired - expired and removed by GC or DeleteExpired
+	ReasonExpired
+
+	// ReasonCleared - removed by ClearAll or Shutdown
+	ReasonCleared
+
+	// ReasonPingFailed - ping failed on read (see GetWith) or on GC health check (see WithHealthCheckOnGC)
+	ReasonPingFailed
+
+	// ReasonKeepAliveFailed - keepalive pings failed too many times (see WithKeepAlive)
+	ReasonKeepAliveFailed
+
+	// ReasonRefreshed - replaced by refreshed connection (see WithServeStale)
+	ReasonRefreshed
+
+	// ReasonReconnected - dead connection replaced by reconnected one (see SetOptions.Connect)
+	ReasonReconnected
+
+	// ReasonMaxAge - connection is older than its max age (see WithMaxLifetime, SetOptions.MaxAge)
+	ReasonMaxAge
+
+	// ReasonEvicted - removed on demand to free connections (see Evict, CloseIdle)
+	ReasonEvicted
+
+	// ReasonReplaced - replaced by Set with another connection
+	ReasonReplaced
+
+	// ReasonClosedExternally - connection was closed outside of cache (see WithCloseGuard)
+	ReasonClosedExternally
+
+	// ReasonPolicy - evicted by GC according to eviction policy (see WithEvictionPolicy)
+	ReasonPolicy
+
+	// ReasonRecreated - replaced by connection rebuilt on demand (see Recreate)
+	ReasonRecreated
+
+	// ReasonBudget - idle item evicted to make room within connection budget (see WithConnBudget)
+	ReasonBudget
+
+	// ReasonBadConn - statement failed with connection error (see ExecWithRetry)
+	ReasonBadConn
+
+	// ReasonHealthCheck - background health check ping failed (see WithHealthCheck)
+	ReasonHealthCheck
+
+	// ReasonFailback - replaced by connection to preferred DSN reachable again (see WithFailback)
+	ReasonFailback
+)
+
+// String - returns reason name
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonDeleted:
+		return "deleted"
+	case ReasonExpired:
+		return "expired"
+	case ReasonCleared:
+		return "cleared"
+	case ReasonPingFailed:
+		return "ping-failed"
+	case ReasonKeepAliveFailed:
+		return "keepalive-failed"
+	case ReasonRefreshed:
+		return "refreshed"
+	case ReasonReconnected:
+		return "reconnected"
+	case ReasonMaxAge:
+		return "max-age"
+	case ReasonEvicted:
+		return "evicted"
+	case ReasonReplaced:
+		return "replaced"
+	case ReasonClosedExternally:
+		return "closed-externally"
+	case ReasonPolicy:
+		return "policy"
+	case ReasonRecreated:
+		return "recreated"
+	case ReasonBudget:
+		return "budget"
+	case ReasonBadConn:
+		return "bad-conn"
+	case ReasonHealthCheck:
+		return "health-check"
+	case ReasonFailback:
+		return "failback"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,338 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Automatic reconnect ///////////
+
+const (
+	// defaultReconnectMinBackoff - delay after first failed reconnect attempt
+	defaultReconnectMinBackoff = 100 * time.Millisecond
+
+	// defaultReconnectMaxBackoff - max delay between failed reconnect attempts
+	defaultReconnectMaxBackoff = 30 * time.Second
+)
+
+// ConnectFunc - creates new connection (used to reconnect dead items)
+type ConnectFunc func(ctx context.Context) (*sqlx.DB, error)
+
+// DSNConnect - returns ConnectFunc connecting with sqlx.ConnectContext
+func DSNConnect(driver, dsn string) ConnectFunc {
+	return func(ctx context.Context) (*sqlx.DB, error) {
+		return sqlx.ConnectContext(ctx, driver, dsn)
+	}
+}
+
+// reconnectCall - in-flight or finished reconnect of key
+type reconnectCall struct {
+	done chan struct{}
+	db   *sqlx.DB
+	err  error
+
+	// backoff state (valid after done is closed)
+	failures    int
+	nextAttempt time.Time
+}
+
+// GetVerified - getting *sqlx.DB value by key (extends item expiration) and checking it with ping.
+// Dead connection of item set with SetOptions.Connect is transparently reconnected,
+// dead connection of other item is closed and removed from cache.
+// Returns ErrKeyNotFound if key is not found, ErrCircuitOpen if key breaker is open (see WithCircuitBreaker).
+func (c *SafeDbMapCache) GetVerified(ctx context.Context, key string) (_ *sqlx.DB, err error) {
+	key = c.hashKey(key)
+
+	var attrs SpanAttrs
+
+	if c.tracer != nil {
+		var end func(SpanAttrs, error)
+
+		ctx, end = c.startSpan(ctx, SpanGetVerified, key)
+		defer func() { end(attrs, err) }()
+	}
+
+	db, found, err := c.getWith(key, GetOptions{Touch: true})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	attrs.Hit = true
+
+	// ping to check
+	err = c.tracePing(ctx, key, db)
+	if err == nil {
+		return db, nil
+	}
+
+	if c.connectFunc(key, db) == nil {
+		c.evictIfSame(key, db, ReasonPingFailed)
+
+		return nil, err
+	}
+
+	attrs.Reconnected = true
+
+	return c.reconnect(ctx, key, db, ReasonReconnected)
+}
+
+// SetFactory - sets connect func of item used to rebuild its connection (see Recreate,
+// GetVerified, WithKeepAlive, SetOptions.Connect). Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) SetFactory(key string, factory ConnectFunc) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	key = c.hashKey(key)
+
+	c.Lock()
+	defer c.Unlock()
+
+	item, found := c.pool.get(key)
+	if !found {
+		return ErrKeyNotFound
+	}
+
+	item.connect = factory
+
+	c.pool.set(key, item)
+
+	return nil
+}
+
+// Recreate - closes connection of key and replaces it with the new one created by item connect func
+// (see SetFactory). Concurrent recreates and reconnects of the same key are deduplicated.
+// Returns ErrKeyNotFound if key is not found, ErrNoFactory if item has no connect func.
+// On connect error old connection is kept.
+func (c *SafeDbMapCache) Recreate(ctx context.Context, key string) (*sqlx.DB, error) {
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+
+	key = c.hashKey(key)
+
+	db, found, _ := c.getWith(key, GetOptions{})
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+
+	if c.connectFunc(key, db) == nil {
+		return nil, ErrNoFactory
+	}
+
+	return c.reconnect(ctx, key, db, ReasonRecreated)
+}
+
+// RecreateAll - recreates connections of all items having connect func (see Recreate) one by one
+// in key order, e.g. after credential rotation. Key, TTL and metadata are kept, items without connect
+// func are left untouched. On connect error old connection of key is kept. Returns *RecreateError
+// describing failed keys (keys left when ctx is done fail with ctx error).
+func (c *SafeDbMapCache) RecreateAll(ctx context.Context) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	c.RLock()
+
+	targets := make([]pingTarget, 0, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		if i.connect != nil {
+			targets = append(targets, pingTarget{key: k, db: i.Db})
+		}
+	})
+
+	c.RUnlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].key < targets[j].key
+	})
+
+	errs := make(map[string]error)
+	for _, t := range targets {
+		err := ctx.Err()
+		if err == nil {
+			_, err = c.reconnect(ctx, t.key, t.db, ReasonRecreated)
+		}
+
+		if err != nil {
+			errs[t.key] = fmt.Errorf("dbpool: recreate %q: %w", c.redact(t.key), err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &RecreateError{Errors: errs}
+}
+
+// connectFunc - returns connect func of item if it still holds db
+func (c *SafeDbMapCache) connectFunc(key string, db *sqlx.DB) ConnectFunc {
+	c.RLock()
+	defer c.RUnlock()
+
+	item, found := c.pool.get(key)
+	if !found || item.Db != db {
+		return nil
+	}
+
+	return item.connect
+}
+
+// reconnect - replaces connection old of key with the new one, old one is closed with reason.
+// Concurrent reconnects of the same key are deduplicated, failed attempts are repeated with backoff.
+func (c *SafeDbMapCache) reconnect(ctx context.Context, key string, old *sqlx.DB, reason EvictReason) (*sqlx.DB, error) {
+	c.reconnectMu.Lock()
+
+	call, found := c.reconnects[key]
+	if found {
+		select {
+		case <-call.done:
+			// previous attempt failed recently - wait for backoff
+			if call.err != nil && c.now().Before(call.nextAttempt) {
+				c.reconnectMu.Unlock()
+
+				return nil, fmt.Errorf("dbpool: reconnect backoff: %w", call.err)
+			}
+		default:
+			// reconnect is in progress - wait for it
+			c.reconnectMu.Unlock()
+
+			select {
+			case <-call.done:
+				return call.db, call.err
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+
+	var failures int
+	if found {
+		failures = call.failures
+	}
+
+	call = &reconnectCall{done: make(chan struct{}), failures: failures}
+	c.reconnects[key] = call
+
+	c.reconnectMu.Unlock()
+
+	call.db, call.err = c.doReconnect(ctx, key, old, reason)
+
+	c.reconnectMu.Lock()
+
+	if call.err != nil {
+		call.failures++
+		call.nextAttempt = c.now().Add(c.reconnectBackoff(call.failures))
+	} else {
+		delete(c.reconnects, key)
+	}
+
+	close(call.done)
+
+	c.reconnectMu.Unlock()
+
+	return call.db, call.err
+}
+
+// doReconnect - connects with item connect func and swaps new connection into cache
+func (c *SafeDbMapCache) doReconnect(ctx context.Context, key string, old *sqlx.DB, reason EvictReason) (*sqlx.DB, error) {
+	connect := c.connectFunc(key, old)
+	if connect == nil {
+		// item was replaced or removed meanwhile
+		db, found, _ := c.getWith(key, GetOptions{Touch: true})
+		if !found {
+			return nil, ErrKeyNotFound
+		}
+
+		return db, nil
+	}
+
+	if !c.allowDial(key) {
+		return nil, ErrDialRateLimited
+	}
+
+	db, err := c.traceConnect(ctx, key, connect)
+	if err != nil {
+		Logger.Warningf("db connection reconnect error: %s", err.Error())
+
+		return nil, err
+	}
+
+	c.Lock()
+
+	item, found := c.pool.get(key)
+
+	// item was replaced or removed meanwhile
+	if !found || item.Db != old {
+		c.Unlock()
+
+		_ = c.closeDb(key, db)
+		if !found {
+			return nil, ErrKeyNotFound
+		}
+
+		return item.Db, nil
+	}
+
+	replaced := item
+
+	item.Db = db
+	item.setAccessed(c.now())
+	item.FirstCreated = c.now()
+	item.pingFailures = 0
+	item.renewDeadlines(c.now())
+
+	c.insertItem(key, item)
+
+	c.Unlock()
+
+	item.connSettings.apply(db)
+
+	c.closeRemoved([]removedItem{{key: key, item: replaced, reason: reason}})
+
+	return db, nil
+}
+
+// rotate - replaces outlived connection old of key with the new one,
+// removes item if new connection can't be created
+func (c *SafeDbMapCache) rotate(key string, old *sqlx.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+
+	_, err := c.reconnect(ctx, key, old, ReasonMaxAge)
+	if err != nil {
+		c.evictIfSame(key, old, ReasonMaxAge)
+	}
+}
+
+// forgetReconnect - drops reconnect backoff state of removed key
+func (c *SafeDbMapCache) forgetReconnect(key string) {
+	c.reconnectMu.Lock()
+	delete(c.reconnects, key)
+	c.reconnectMu.Unlock()
+}
+
+// reconnectBackoff - returns delay before next reconnect attempt after failures failed ones
+func (c *SafeDbMapCache) reconnectBackoff(failures int) time.Duration {
+	backoff := c.reconnectMinBackoff
+	for i := 1; i < failures && backoff < c.reconnectMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > c.reconnectMaxBackoff {
+		backoff = c.reconnectMaxBackoff
+	}
+
+	return backoff
+}
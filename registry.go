@@ -0,0 +1,352 @@
+package dbpool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Lazy connections registered by driver + DSN ///////////
+
+// defaultDialTimeout - timeout of registered connection dial
+const defaultDialTimeout = 30 * time.Second
+
+// registration - connection parameters registered by RegisterDSN
+type registration struct {
+	driver   string
+	dsn      string
+	ttl      time.Duration
+	setup    func(db *sqlx.DB)
+	metadata map[string]string
+	maxAge   time.Duration
+	dialRate dialRate
+
+	// standby DSNs tried in order after dsn, index of DSN in use (0 - dsn) and
+	// number of switches to the next DSNs (see WithFailoverDSNs)
+	standby   []string
+	active    int
+	failovers int
+
+	// in-flight dial (nil if none)
+	dialing *dialCall
+
+	// last dial error remembered until failedUntil (see WithDialFailureTTL)
+	failure     error
+	failedUntil time.Time
+}
+
+// dialCall - in-flight dial of registration
+type dialCall struct {
+	done chan struct{}
+	db   *sqlx.DB
+	err  error
+}
+
+// RegisterOption - RegisterDSN option
+type RegisterOption func(*registration)
+
+// WithSetup - sets function applied to every connection dialed for registration
+// (pool limits, mapper, etc.) before it is stored in cache
+func WithSetup(setup func(db *sqlx.DB)) RegisterOption {
+	return func(r *registration) {
+		r.setup = setup
+	}
+}
+
+// WithMetadata - sets metadata of items dialed for registration
+func WithMetadata(metadata map[string]string) RegisterOption {
+	return func(r *registration) {
+		r.metadata = copyMetadata(metadata)
+	}
+}
+
+// WithMaxAge - sets max age of connections dialed for registration (see SetOptions.MaxAge),
+// outlived connection is redialed transparently
+func WithMaxAge(maxAge time.Duration) RegisterOption {
+	return func(r *registration) {
+		r.maxAge = maxAge
+	}
+}
+
+// WithDialRate - overrides pool dial rate limit (see WithDialRateLimit) for registration
+func WithDialRate(n int, period time.Duration) RegisterOption {
+	return func(r *registration) {
+		r.dialRate = dialRate{n: n, period: period}
+	}
+}
+
+// WithFailoverDSNs - sets standby DSNs of registration: dial and reconnect try registered DSN
+// and then standby ones in order, the first reachable one is used (see WithFailback, ItemInfo.Endpoint)
+func WithFailoverDSNs(dsns ...string) RegisterOption {
+	return func(r *registration) {
+		r.standby = append([]string(nil), dsns...)
+	}
+}
+
+// RegisterDSN - registering connection parameters of key without connecting.
+// Connection is dialed by first GetOrConnect and redialed after item removal.
+// Re-registration of key replaces its parameters (already opened connection is kept).
+func (c *SafeDbMapCache) RegisterDSN(key, driverName, dsn string, ttl time.Duration, opts ...RegisterOption) {
+	reg := &registration{
+		driver: driverName,
+		dsn:    dsn,
+		ttl:    ttl,
+	}
+
+	for _, opt := range opts {
+		opt(reg)
+	}
+
+	c.registryMu.Lock()
+	c.registry[key] = reg
+	c.registryMu.Unlock()
+
+	c.setDialRate(c.hashKey(key), reg.dialRate)
+}
+
+// Unregister - removing registered connection parameters of key (opened connection is kept)
+func (c *SafeDbMapCache) Unregister(key string) {
+	c.registryMu.Lock()
+	delete(c.registry, key)
+	c.registryMu.Unlock()
+
+	c.setDialRate(c.hashKey(key), dialRate{})
+	c.forgetDialBucket(c.hashKey(key))
+}
+
+// ClearFailure - forgets remembered dial failure of registered key (see WithDialFailureTTL)
+func (c *SafeDbMapCache) ClearFailure(key string) {
+	c.registryMu.Lock()
+	defer c.registryMu.Unlock()
+
+	if reg, found := c.registry[key]; found {
+		reg.failure = nil
+	}
+}
+
+// GetOrConnect - getting *sqlx.DB value by key (extends item expiration),
+// dials registered connection (see RegisterDSN) if item is not in cache.
+// Concurrent calls for the same key result in exactly one dial, dial error is returned
+// to all of them and doesn't affect registration (but is remembered, see WithDialFailureTTL).
+// Returns ErrKeyNotFound for unknown key, ErrClosed without dial after Shutdown.
+func (c *SafeDbMapCache) GetOrConnect(ctx context.Context, key string) (db *sqlx.DB, err error) {
+	var hit bool
+
+	if c.tracer != nil {
+		var end func(SpanAttrs, error)
+
+		ctx, end = c.startSpan(ctx, SpanGetOrConnect, c.hashKey(key))
+		defer func() { end(SpanAttrs{Hit: hit}, err) }()
+	}
+
+	if db, found := c.Get(key); found {
+		hit = true
+		return db, nil
+	}
+
+	if c.isClosed() {
+		return nil, ErrClosed
+	}
+
+	c.registryMu.Lock()
+
+	reg, found := c.registry[key]
+	if !found {
+		c.registryMu.Unlock()
+
+		return nil, ErrKeyNotFound
+	}
+
+	// recently failed dial isn't repeated
+	if reg.failure != nil && c.now().Before(reg.failedUntil) {
+		err := reg.failure
+		c.registryMu.Unlock()
+
+		return nil, fmt.Errorf("dbpool: recent dial failure: %w", err)
+	}
+
+	call := reg.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		reg.dialing = call
+
+		go c.dial(key, reg, call)
+	}
+
+	c.registryMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.db, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial - connects registration and stores connection in cache
+func (c *SafeDbMapCache) dial(key string, reg *registration, call *dialCall) {
+	defer func() {
+		c.registryMu.Lock()
+
+		reg.dialing = nil
+
+		reg.failure = nil
+		if call.err != nil && c.dialFailureTTL > 0 {
+			reg.failure = call.err
+			reg.failedUntil = c.now().Add(c.dialFailureTTL)
+		}
+
+		c.registryMu.Unlock()
+
+		close(call.done)
+	}()
+
+	// item could be stored by concurrent dial finished right before this one started
+	if db, found := c.Get(key); found {
+		call.db = db
+		return
+	}
+
+	if !c.allowDial(c.hashKey(key)) {
+		call.err = ErrDialRateLimited
+		return
+	}
+
+	// dial is shared by callers - it isn't bound to their contexts
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+
+	connect := c.failoverConnect(key, reg)
+	if reg.setup != nil {
+		connect = withSetup(connect, reg.setup)
+	}
+
+	call.db, call.err = c.connectRetry(ctx, c.hashKey(key), connect)
+	if call.err != nil {
+		return
+	}
+
+	call.err = c.TrySet(key, call.db, reg.ttl, SetOptions{
+		Metadata: reg.metadata,
+		Connect:  connect,
+		MaxAge:   reg.maxAge,
+	})
+	if call.err != nil {
+		_ = c.closeDb(c.hashKey(key), call.db)
+		call.db = nil
+	}
+}
+
+// withSetup - returns connect func applying setup to new connections
+func withSetup(connect ConnectFunc, setup func(db *sqlx.DB)) ConnectFunc {
+	return func(ctx context.Context) (*sqlx.DB, error) {
+		db, err := connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		setup(db)
+
+		return db, nil
+	}
+}
+
+// WarmupError - Warmup error with dial errors by internal key (see WithHashedKeys), like CloseError.
+// Keys aren't redacted, each error names its redacted key (see WithKeyRedactor).
+type WarmupError struct {
+	Errors map[string]error
+}
+
+// Error - returns dial errors of all failed keys sorted by message
+func (e *WarmupError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	sort.Strings(msgs)
+
+	return fmt.Sprintf("dbpool: warmup failed for %d key(s): %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Warmup - dials all registered (see RegisterDSN) but not opened connections,
+// at most concurrency dials at once. Returns *WarmupError describing failed keys
+// (they stay registered and can be dialed later). Keys not dialed because of ctx
+// cancellation are reported with ctx error.
+func (c *SafeDbMapCache) Warmup(ctx context.Context, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	c.registryMu.Lock()
+	keys := make([]string, 0, len(c.registry))
+	for k := range c.registry {
+		keys = append(keys, k)
+	}
+	c.registryMu.Unlock()
+
+	sort.Strings(keys)
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+		sem  = make(chan struct{}, concurrency)
+	)
+
+	for _, key := range keys {
+		if _, found := c.Peek(key); found {
+			continue
+		}
+
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+
+		if ctx.Err() != nil {
+			mu.Lock()
+			errs[key] = ctx.Err()
+			mu.Unlock()
+
+			continue
+		}
+
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			_, err := c.GetOrConnect(ctx, key)
+			if err != nil {
+				mu.Lock()
+				errs[key] = err
+				mu.Unlock()
+			}
+		}(key)
+	}
+
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	// registry keys are often DSNs, so messages name redacted keys
+	failed := make(map[string]error, len(errs))
+	for k, err := range errs {
+		internal := c.hashKey(k)
+		failed[internal] = fmt.Errorf("dbpool: dial %q: %w", c.redact(internal), err)
+	}
+
+	return &WarmupError{Errors: failed}
+}
@@ -0,0 +1,237 @@
+package dbpool
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/////// Serializable SafeDbMapCache report ///////////
+
+// ReportItem - serializable pool item state
+type ReportItem struct {
+	Created   time.Time     `json:"created"`
+	Duration  time.Duration `json:"duration"`
+	ExpiresIn time.Duration `json:"expires_in"` // 0 - never expires
+
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	DBStats sql.DBStats `json:"db_stats"`
+}
+
+// Report - serializable pool state (ready for json encoding)
+type Report struct {
+	Size  int                   `json:"size"`
+	Items map[string]ReportItem `json:"items"`
+
+	// History - recent pool operations (see WithHistory)
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// Report - returns serializable pool state. Items are keyed by redacted keys (see WithKeyRedactor),
+// items with the same redacted key are told apart by "#2", "#3", etc. suffix.
+func (c *SafeDbMapCache) Report() Report {
+	history := c.History()
+
+	c.RLock()
+
+	now := c.now().UnixNano()
+
+	items := make(map[string]ReportItem, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		var expiresIn time.Duration
+		if deadline := i.deadline(); deadline > 0 {
+			expiresIn = time.Duration(deadline - now)
+		}
+
+		items[k] = ReportItem{
+			Created:   i.lastAccess(),
+			Duration:  i.Duration,
+			ExpiresIn: expiresIn,
+			Metadata:  copyMetadata(i.Metadata),
+			DBStats:   i.Db.Stats(),
+		}
+	})
+
+	c.RUnlock()
+
+	// keys are redacted without cache lock
+	report := Report{
+		Size:  len(items),
+		Items: make(map[string]ReportItem, len(items)),
+
+		History: history,
+	}
+
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		report.Items[reportKey(report.Items, c.redact(k))] = items[k]
+	}
+
+	return report
+}
+
+// reportKey - returns redacted key not used by items yet (suffixed on redaction collision)
+func reportKey(items map[string]ReportItem, key string) string {
+	if _, found := items[key]; !found {
+		return key
+	}
+
+	for n := 2; ; n++ {
+		k := fmt.Sprintf("%s#%d", key, n)
+		if _, found := items[k]; !found {
+			return k
+		}
+	}
+}
+
+// ItemInfo - pool item description (without connection)
+type ItemInfo struct {
+	Key          string
+	Created      time.Time
+	FirstCreated time.Time
+	Duration     time.Duration
+	Expiration   time.Time // nearer of idle and absolute deadlines, zero - never expires
+
+	Metadata map[string]string
+
+	// Breaker, BreakerFailures - circuit breaker state and consecutive failures (see WithCircuitBreaker)
+	Breaker         BreakerState
+	BreakerFailures int
+
+	// HealthChecks - ping attempts of the last health check (see WithHealthCheckRetry)
+	HealthChecks []HealthAttempt
+
+	// Unhealthy - the last background health check failed (see MarkUnhealthy)
+	Unhealthy bool
+
+	// Replicas, ReplicasDown - number of replicas of primary and number of them failed
+	// the last health check (see SetRoles)
+	Replicas     int
+	ReplicasDown int
+
+	// Endpoint, Failovers - index of registered DSN in use (0 - preferred one) and number of
+	// failovers to the next DSNs (see WithFailoverDSNs)
+	Endpoint  int
+	Failovers int
+}
+
+// itemInfo - returns description of pool item
+func itemInfo(key string, item PoolItem) ItemInfo {
+	var expiration time.Time
+	if deadline := item.deadline(); deadline > 0 {
+		expiration = time.Unix(0, deadline)
+	}
+
+	return ItemInfo{
+		Key:          key,
+		Created:      item.lastAccess(),
+		FirstCreated: item.FirstCreated,
+		Duration:     item.Duration,
+		Expiration:   expiration,
+		Metadata:     copyMetadata(item.Metadata),
+		HealthChecks: append([]HealthAttempt(nil), item.healthChecks...),
+		Unhealthy:    item.unhealthy,
+		Replicas:     item.replicas(),
+		ReplicasDown: item.replicasDown(),
+	}
+}
+
+// GetItem - returns description of item by key with its metadata (labels), doesn't extend item expiration
+func (c *SafeDbMapCache) GetItem(key string) (ItemInfo, bool) {
+	key = c.hashKey(key)
+
+	c.RLock()
+	item, found := c.pool.get(key)
+	c.RUnlock()
+
+	if !found {
+		return ItemInfo{}, false
+	}
+
+	info := itemInfo(key, item)
+	info.Breaker, info.BreakerFailures = c.breakerState(key)
+
+	if e, found := c.endpoints()[key]; found {
+		info.Endpoint, info.Failovers = e.active, e.failovers
+	}
+	info.Key = c.displayKeys([]string{key})[0]
+
+	return info, true
+}
+
+// ItemsInfo - returns descriptions of all pool items sorted by key
+func (c *SafeDbMapCache) ItemsInfo() []ItemInfo {
+	c.RLock()
+
+	infos := make([]ItemInfo, 0, c.pool.len())
+	c.pool.each(func(k string, i PoolItem) {
+		infos = append(infos, itemInfo(k, i))
+	})
+
+	c.RUnlock()
+
+	endpoints := c.endpoints()
+
+	for i := range infos {
+		infos[i].Breaker, infos[i].BreakerFailures = c.breakerState(infos[i].Key)
+
+		if e, found := endpoints[infos[i].Key]; found {
+			infos[i].Endpoint, infos[i].Failovers = e.active, e.failovers
+		}
+		infos[i].Key = c.displayKeys([]string{infos[i].Key})[0]
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos
+}
+
+// StateDump - pool diagnostics snapshot (see DumpState)
+type StateDump struct {
+	DefaultExpiration time.Duration `json:"default_expiration"`
+	CleanupInterval   time.Duration `json:"cleanup_interval"`
+
+	Stats Stats `json:"stats"`
+
+	Items []ItemInfo `json:"items"`
+
+	// Truncated - number of items omitted because of max items limit
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// DumpState - returns json encoded pool diagnostics snapshot: settings, counters and
+// up to maxItems (zero or negative - all) items sorted by key. Keys are redacted with
+// WithKeyRedactor function, connections and DSNs are never included.
+func (c *SafeDbMapCache) DumpState(maxItems int) ([]byte, error) {
+	items := c.ItemsInfo()
+
+	dump := StateDump{
+		DefaultExpiration: c.DefaultExpiration(),
+		CleanupInterval:   c.CleanupInterval(),
+		Stats:             c.Stats(),
+	}
+
+	if maxItems > 0 && len(items) > maxItems {
+		dump.Truncated = len(items) - maxItems
+		items = items[:maxItems]
+	}
+
+	for i := range items {
+		items[i].Key = c.redact(items[i].Key)
+	}
+
+	dump.Items = items
+
+	return json.Marshal(dump)
+}
@@ -0,0 +1,98 @@
+package dbpool
+
+import (
+	"sync"
+)
+
+/////// Sharded item map ///////////
+
+// defaultShards - default number of item map shards (see WithShards)
+const defaultShards = 16
+
+// poolShard - part of item map with its own lock
+type poolShard struct {
+	sync.RWMutex
+	items map[string]PoolItem
+}
+
+// itemMap - pool items split into shards by key hash, so Get-like reads of unrelated keys
+// don't contend on the same lock. Items are changed under cache write lock (it keeps invariants
+// spanning keys: refs, budget, namespaces, indexes) and shard write lock, so code holding
+// cache lock reads items without shard locks, Get-like reads take shard read lock only.
+type itemMap struct {
+	shards []*poolShard
+}
+
+// newItemMap - returns empty item map of n shards (at least one)
+func newItemMap(n int) *itemMap {
+	if n < 1 {
+		n = 1
+	}
+
+	m := &itemMap{shards: make([]*poolShard, n)}
+	for i := range m.shards {
+		m.shards[i] = &poolShard{items: make(map[string]PoolItem)}
+	}
+
+	return m
+}
+
+// shard - returns shard of key (FNV-1a hash of key)
+func (m *itemMap) shard(key string) *poolShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+
+	return m.shards[h%uint32(len(m.shards))]
+}
+
+// get - returns item of key (must be called under cache lock)
+func (m *itemMap) get(key string) (PoolItem, bool) {
+	item, found := m.shard(key).items[key]
+
+	return item, found
+}
+
+// item - returns item of key, zero item if key is not found (must be called under cache lock)
+func (m *itemMap) item(key string) PoolItem {
+	return m.shard(key).items[key]
+}
+
+// set - puts item of key (must be called under cache write lock)
+func (m *itemMap) set(key string, item PoolItem) {
+	s := m.shard(key)
+
+	s.Lock()
+	s.items[key] = item
+	s.Unlock()
+}
+
+// remove - removes item of key (must be called under cache write lock)
+func (m *itemMap) remove(key string) {
+	s := m.shard(key)
+
+	s.Lock()
+	delete(s.items, key)
+	s.Unlock()
+}
+
+// len - returns number of items (must be called under cache lock)
+func (m *itemMap) len() int {
+	n := 0
+	for _, s := range m.shards {
+		n += len(s.items)
+	}
+
+	return n
+}
+
+// each - calls fn for every item shard by shard (must be called under cache lock)
+func (m *itemMap) each(fn func(key string, item PoolItem)) {
+	for _, s := range m.shards {
+		for k, i := range s.items {
+			fn(k, i)
+		}
+	}
+}
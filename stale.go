@@ -0,0 +1,109 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Serve-stale (stale-while-revalidate) mode ///////////
+
+// RefreshFunc - creates new connection for key (used to refresh stale items)
+type RefreshFunc func(ctx context.Context, key string) (*sqlx.DB, error)
+
+// GetStale - getting *sqlx.DB value by key (extends item expiration).
+// In serve-stale mode (see WithServeStale) expired item is returned with stale == true
+// until hard expiry, meanwhile connection is refreshed in background.
+func (c *SafeDbMapCache) GetStale(key string) (db *sqlx.DB, found bool, stale bool) {
+	hk := c.hashKey(key)
+
+	db, found, _ = c.getWith(hk, GetOptions{Touch: true})
+	if found || c.refresh == nil {
+		return db, found, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	// only item past its expiration is stale: suspect one (see HealthRetry.Strict)
+	// and one with connection closed outside of cache are just missing
+	item, found := c.pool.get(hk)
+	if !found || item.suspect() || item.deadline() <= 0 || c.now().UnixNano() <= item.deadline() {
+		return nil, false, false
+	}
+
+	// hard expired - GC will remove it
+	if c.ttlExpired(item) || isDbClosed(item.Db) {
+		return nil, false, false
+	}
+
+	if _, ok := c.refreshing[hk]; !ok {
+		c.refreshing[hk] = struct{}{}
+
+		go c.refreshItem(key, hk, item.Db)
+	}
+
+	return item.pick(), true, true
+}
+
+// refreshDial - creates new connection for stale item with refresh func in free dial slot
+// (see WithMaxConcurrentDials)
+func (c *SafeDbMapCache) refreshDial(ctx context.Context, raw string) (*sqlx.DB, error) {
+	err := c.acquireDial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseDial()
+
+	return c.refresh(ctx, raw)
+}
+
+// refreshItem - creates new connection for stale item (by its raw key) and swaps it into cache
+// (by its internal key, see WithHashedKeys)
+func (c *SafeDbMapCache) refreshItem(raw, key string, old *sqlx.DB) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.staleTTL)
+	defer cancel()
+
+	db, err := c.refreshDial(ctx, raw)
+
+	c.Lock()
+
+	delete(c.refreshing, key)
+
+	if err != nil {
+		c.Unlock()
+
+		Logger.Warningf("db connection of key %s refresh error: %s", c.redact(key), err.Error())
+		return
+	}
+
+	item, found := c.pool.get(key)
+
+	// item was replaced or removed meanwhile
+	if !found || item.Db != old {
+		c.Unlock()
+
+		err = c.closeDb(key, db)
+		if err != nil {
+			Logger.Warningf("db connection of key %s close error: %s", c.redact(key), err.Error())
+		}
+		return
+	}
+
+	replaced := item
+
+	item.Db = db
+	item.setAccessed(c.now())
+	item.FirstCreated = c.now()
+	item.renewDeadlines(c.now())
+
+	c.insertItem(key, item)
+
+	c.Unlock()
+
+	item.connSettings.apply(db)
+
+	c.closeRemoved([]removedItem{{key: key, item: replaced, reason: ReasonRefreshed}})
+}
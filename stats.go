@@ -0,0 +1,86 @@
+package dbpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/////// SafeDbMapCache statistics ///////////
+
+// Stats - cache statistics snapshot
+type Stats struct {
+	// Items - current number of pooled items
+	Items int
+
+	// GCInterval - currently effective GC interval (0 if GC is disabled)
+	GCInterval time.Duration
+
+	// LastGCDuration - duration of the last GC sweep
+	LastGCDuration time.Duration
+
+	// LastGCEvicted - number of items evicted by the last GC sweep
+	LastGCEvicted int
+
+	// PanicCount - total number of GC sweep panics recovered by GC loop (see WithGCRepanic)
+	PanicCount int64
+
+	// DeferredEvictions - total number of evictions deferred because connection was in use
+	DeferredEvictions int64
+
+	// PendingClose - number of GC evicted items waiting for close (see WithCloseDelay)
+	PendingClose int
+
+	// Hits, Misses - total number of Get-like reads which found and didn't find item
+	Hits   int64
+	Misses int64
+
+	// Retries, FailedRetries - total number of statements retried on fresh connection after
+	// connection error and number of them failed again (see ExecWithRetry)
+	Retries       int64
+	FailedRetries int64
+
+	// DroppedEvents - total number of eviction events dropped because Events consumer was slow
+	DroppedEvents int64
+
+	// BudgetUsed, BudgetLimit - connections charged and pool-wide connection budget (0 - no budget),
+	// see WithConnBudget
+	BudgetUsed  int
+	BudgetLimit int
+
+	// DialsInFlight, DialsQueued - dials currently running and waiting for free slot
+	// (see WithMaxConcurrentDials)
+	DialsInFlight int
+	DialsQueued   int64
+}
+
+// Stats - returns cache statistics snapshot
+func (c *SafeDbMapCache) Stats() Stats {
+	c.RLock()
+	items := c.pool.len()
+	budgetUsed := c.budgetUsed
+	c.RUnlock()
+
+	return Stats{
+		Items:          items,
+		GCInterval:     time.Duration(atomic.LoadInt64(&c.gcInterval)),
+		LastGCDuration: time.Duration(atomic.LoadInt64(&c.lastGCDuration)),
+		LastGCEvicted:  int(atomic.LoadInt64(&c.lastGCEvicted)),
+		PanicCount:     atomic.LoadInt64(&c.gcPanics),
+
+		DeferredEvictions: atomic.LoadInt64(&c.deferredEvictions),
+		PendingClose:      c.pendingCount(),
+		DroppedEvents:     atomic.LoadInt64(&c.droppedEvents),
+
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+
+		Retries:       atomic.LoadInt64(&c.retries),
+		FailedRetries: atomic.LoadInt64(&c.failedRetries),
+
+		BudgetUsed:  budgetUsed,
+		BudgetLimit: c.budget,
+
+		DialsInFlight: len(c.dialSlots),
+		DialsQueued:   atomic.LoadInt64(&c.dialQueued),
+	}
+}
@@ -0,0 +1,247 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Prepared statements cache per connection ///////////
+
+// defaultStmtCacheSize - max number of cached prepared statements per connection
+const defaultStmtCacheSize = 32
+
+// stmtCache - LRU cache of prepared statements of one connection
+type stmtCache struct {
+	sync.Mutex
+
+	size   int
+	lru    *list.List // of *stmtEntry, front - most recently used
+	stmts  map[string]*list.Element
+	pinned int
+}
+
+// stmtEntry - cached prepared statement, evicted one is closed when its last user releases it.
+// Pinned statement is never evicted by LRU (see PreparedGet).
+type stmtEntry struct {
+	query   string
+	stmt    *sqlx.Stmt
+	users   int
+	evicted bool
+	pinned  bool
+}
+
+// use - marks statement as pinned or used (must be called under cache lock)
+func (s *stmtCache) use(entry *stmtEntry, pin bool) {
+	if !pin {
+		entry.users++
+		return
+	}
+
+	if !entry.pinned {
+		entry.pinned = true
+		s.pinned++
+	}
+}
+
+// acquire - returns cached statement of query marked as pinned or used (nil if not cached)
+func (s *stmtCache) acquire(query string, pin bool) *stmtEntry {
+	s.Lock()
+	defer s.Unlock()
+
+	el, found := s.stmts[query]
+	if !found {
+		return nil
+	}
+
+	s.lru.MoveToFront(el)
+
+	entry := el.Value.(*stmtEntry)
+	s.use(entry, pin)
+
+	return entry
+}
+
+// add - caches prepared statement (or returns concurrently cached one) marked as pinned or used,
+// returns statements evicted to keep cache size (pinned statements aren't counted)
+func (s *stmtCache) add(query string, stmt *sqlx.Stmt, pin bool) (*stmtEntry, []*sqlx.Stmt) {
+	s.Lock()
+	defer s.Unlock()
+
+	// prepared concurrently - duplicate is closed
+	if el, found := s.stmts[query]; found {
+		s.lru.MoveToFront(el)
+
+		entry := el.Value.(*stmtEntry)
+		s.use(entry, pin)
+
+		return entry, []*sqlx.Stmt{stmt}
+	}
+
+	entry := &stmtEntry{query: query, stmt: stmt}
+	s.use(entry, pin)
+	s.stmts[query] = s.lru.PushFront(entry)
+
+	var closing []*sqlx.Stmt
+	for el := s.lru.Back(); el != nil && s.lru.Len()-s.pinned > s.size; {
+		prev := el.Prev()
+
+		old := el.Value.(*stmtEntry)
+		if !old.pinned {
+			s.lru.Remove(el)
+			delete(s.stmts, old.query)
+
+			old.evicted = true
+			if old.users == 0 {
+				closing = append(closing, old.stmt)
+			}
+		}
+
+		el = prev
+	}
+
+	return entry, closing
+}
+
+// release - marks statement as not used, returns true if evicted statement should be closed
+func (s *stmtCache) release(entry *stmtEntry) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	entry.users--
+
+	return entry.evicted && entry.users == 0
+}
+
+// evictAll - evicts all statements, returns not used ones to close
+func (s *stmtCache) evictAll() []*sqlx.Stmt {
+	s.Lock()
+	defer s.Unlock()
+
+	var closing []*sqlx.Stmt
+	for el := s.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*stmtEntry)
+
+		entry.evicted = true
+		if entry.users == 0 {
+			closing = append(closing, entry.stmt)
+		}
+	}
+
+	s.lru.Init()
+	s.stmts = make(map[string]*list.Element)
+	s.pinned = 0
+
+	return closing
+}
+
+// closeStmts - closes prepared statements
+func closeStmts(stmts []*sqlx.Stmt) {
+	for _, stmt := range stmts {
+		err := stmt.Close()
+		if err != nil {
+			Logger.Warningf("db prepared statement close error: %s", err.Error())
+		}
+	}
+}
+
+// stmtCacheOf - returns statements cache of connection (nil if connection is removed from cache)
+func (c *SafeDbMapCache) stmtCacheOf(db *sqlx.DB) *stmtCache {
+	c.stmtsMu.Lock()
+	defer c.stmtsMu.Unlock()
+
+	cache, found := c.stmts[db]
+	if !found {
+		// removed connection would never drop its statements
+		if !c.shared(db) {
+			return nil
+		}
+
+		cache = &stmtCache{
+			size:  c.stmtCacheSize,
+			lru:   list.New(),
+			stmts: make(map[string]*list.Element),
+		}
+
+		c.stmts[db] = cache
+	}
+
+	return cache
+}
+
+// dropStmts - closes cached statements of connection being closed
+func (c *SafeDbMapCache) dropStmts(db *sqlx.DB) {
+	c.stmtsMu.Lock()
+	cache, found := c.stmts[db]
+	delete(c.stmts, db)
+	c.stmtsMu.Unlock()
+
+	if found {
+		closeStmts(cache.evictAll())
+	}
+}
+
+// PreparedQueryx - runs query with connection of key as prepared statement. Statements are prepared
+// lazily and cached per connection (see WithStmtCacheSize), they are closed with connection,
+// so reconnected or replaced connection prepares them again. Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) PreparedQueryx(ctx context.Context, key, query string, args ...interface{}) (*sqlx.Rows, error) {
+	cache, entry, err := c.prepared(ctx, key, query, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// rows keep statement alive even if it is closed meanwhile
+	rows, err := entry.stmt.QueryxContext(ctx, args...)
+
+	if cache.release(entry) {
+		closeStmts([]*sqlx.Stmt{entry.stmt})
+	}
+
+	return rows, c.observe(key, err)
+}
+
+// PreparedGet - returns prepared statement of query on connection of key, prepares and caches it
+// on first call (read-through). Statement is owned by cache and must not be closed by caller:
+// it is pinned (not evicted to keep WithStmtCacheSize) and closed right before its connection
+// is closed (Delete, GC, reconnect, etc.). Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) PreparedGet(key, query string) (*sqlx.Stmt, error) {
+	_, entry, err := c.prepared(context.Background(), key, query, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.stmt, nil
+}
+
+// prepared - returns cached prepared statement of query on connection of key marked as pinned
+// or used (see stmtCache.release), prepares it on miss
+func (c *SafeDbMapCache) prepared(ctx context.Context, key, query string, pin bool) (*stmtCache, *stmtEntry, error) {
+	db, err := c.conn(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cache := c.stmtCacheOf(db)
+	if cache == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	entry := cache.acquire(query, pin)
+	if entry == nil {
+		stmt, err := db.PreparexContext(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var closing []*sqlx.Stmt
+		entry, closing = cache.add(query, stmt, pin)
+
+		closeStmts(closing)
+	}
+
+	return cache, entry, nil
+}
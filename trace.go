@@ -0,0 +1,122 @@
+package dbpool
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Tracing of connection opens and pings ///////////
+
+const (
+	// SpanConnect - span name of connection open (GetOrCreate, GetOrConnect, Warmup, reconnect)
+	SpanConnect = "dbpool.connect"
+
+	// SpanPing - span name of connection ping (GetAlive, GetVerified)
+	SpanPing = "dbpool.ping"
+
+	// SpanGetOrConnect - span name of GetOrConnect (parent of connect span on miss)
+	SpanGetOrConnect = "dbpool.get_or_connect"
+
+	// SpanGetVerified - span name of GetVerified (parent of ping and connect spans)
+	SpanGetVerified = "dbpool.get_verified"
+
+	// SpanTx - span name of WithTx
+	SpanTx = "dbpool.tx"
+)
+
+// Tracer - minimal tracing interface (see WithTracer). Start starts span with (redacted) key
+// attribute and returns function ending it with error recorded (if any).
+// It keeps tracing libraries out of pool dependencies, e.g. OpenTelemetry adapter:
+//
+//	type otelTracer struct{ tracer trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name, key string) (context.Context, func(err error)) {
+//		ctx, end := t.StartOp(ctx, name, key)
+//
+//		return ctx, func(err error) { end(dbpool.SpanAttrs{}, err) }
+//	}
+//
+//	func (t otelTracer) StartOp(ctx context.Context, name, key string) (context.Context, func(dbpool.SpanAttrs, error)) {
+//		ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attribute.String("dbpool.key", key)))
+//
+//		return ctx, func(attrs dbpool.SpanAttrs, err error) {
+//			if name != dbpool.SpanConnect && name != dbpool.SpanPing {
+//				span.SetAttributes(attribute.Bool("dbpool.hit", attrs.Hit),
+//					attribute.Bool("dbpool.reconnected", attrs.Reconnected))
+//			}
+//			if err != nil {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			}
+//			span.End()
+//		}
+//	}
+type Tracer interface {
+	Start(ctx context.Context, name, key string) (context.Context, func(err error))
+}
+
+// SpanAttrs - outcome of pool operation (GetOrConnect, GetVerified, WithTx) recorded on its span end
+type SpanAttrs struct {
+	// Hit - item was found in pool
+	Hit bool
+
+	// Reconnected - dead connection was reconnected (see GetVerified)
+	Reconnected bool
+}
+
+// AttrTracer - Tracer also receiving outcome attributes of pool operation spans (see SpanAttrs),
+// spans of other tracers are ended without them
+type AttrTracer interface {
+	Tracer
+
+	StartOp(ctx context.Context, name, key string) (context.Context, func(attrs SpanAttrs, err error))
+}
+
+// startSpan - starts operation span with redacted key (tracer must be set, key is internal)
+func (c *SafeDbMapCache) startSpan(ctx context.Context, name, key string) (context.Context, func(attrs SpanAttrs, err error)) {
+	key = c.redact(key)
+
+	if t, ok := c.tracer.(AttrTracer); ok {
+		return t.StartOp(ctx, name, key)
+	}
+
+	ctx, end := c.tracer.Start(ctx, name, key)
+
+	return ctx, func(_ SpanAttrs, err error) { end(err) }
+}
+
+// traceConnect - opens connection within connect span (if tracer is set),
+// waits for free dial slot first (see WithMaxConcurrentDials)
+func (c *SafeDbMapCache) traceConnect(ctx context.Context, key string, connect ConnectFunc) (*sqlx.DB, error) {
+	err := c.acquireDial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.releaseDial()
+
+	if c.tracer == nil {
+		return connect(ctx)
+	}
+
+	ctx, end := c.tracer.Start(ctx, SpanConnect, c.redact(key))
+
+	db, err := connect(ctx)
+	end(err)
+
+	return db, err
+}
+
+// tracePing - pings connection within ping span (if tracer is set)
+func (c *SafeDbMapCache) tracePing(ctx context.Context, key string, db *sqlx.DB) error {
+	if c.tracer == nil {
+		return db.PingContext(ctx)
+	}
+
+	ctx, end := c.tracer.Start(ctx, SpanPing, c.redact(key))
+
+	err := db.PingContext(ctx)
+	end(err)
+
+	return err
+}
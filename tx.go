@@ -0,0 +1,55 @@
+package dbpool
+
+import (
+	. "github.com/NGRsoftlab/ngr-logging"
+
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Transactions on keyed connections ///////////
+
+// WithTx - runs fn in transaction on connection of key: transaction is committed if fn returns nil
+// and rolled back otherwise (fn panic is re-raised after rollback). Connection is held open
+// until transaction ends even if item is removed meanwhile. Returns ErrKeyNotFound if key is not found.
+func (c *SafeDbMapCache) WithTx(ctx context.Context, key string, opts *sql.TxOptions, fn func(tx *sqlx.Tx) error) (err error) {
+	var db *sqlx.DB
+
+	if c.tracer != nil {
+		var end func(SpanAttrs, error)
+
+		ctx, end = c.startSpan(ctx, SpanTx, c.hashKey(key))
+		defer func() { end(SpanAttrs{Hit: db != nil}, err) }()
+	}
+
+	db, err = c.lease(key)
+	if err != nil {
+		return err
+	}
+	defer c.unlease(key, db)
+
+	tx, err := db.BeginTxx(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			Logger.Warningf("db transaction rollback error: %s", rbErr.Error())
+		}
+
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,57 @@
+package dbpool
+
+import (
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+/////// Read-only pool view ///////////
+
+// PoolView - read-only view of cache for monitoring and handlers code:
+// it can't set, delete items or extend their expiration
+type PoolView struct {
+	c *SafeDbMapCache
+}
+
+// View - returns read-only view of cache
+func (c *SafeDbMapCache) View() PoolView {
+	return PoolView{c: c}
+}
+
+// Has - returns true if key is in cache and not expired (doesn't extend item expiration)
+func (v PoolView) Has(key string) bool {
+	_, res := v.c.read(v.c.hashKey(key), false)
+
+	return res == GetHit
+}
+
+// Peek - getting *sqlx.DB value by key without extending item expiration. Unlike SafeDbMapCache.Peek
+// it has no side effects: stats, hooks, history and eviction policy don't see the read.
+func (v PoolView) Peek(key string) (*sqlx.DB, bool) {
+	db, res := v.c.read(v.c.hashKey(key), false)
+
+	return db, res == GetHit
+}
+
+// Len - returns number of pool items (expired but not removed yet included)
+func (v PoolView) Len() int {
+	v.c.RLock()
+	defer v.c.RUnlock()
+
+	return v.c.pool.len()
+}
+
+// Keys - returns sorted keys of not expired items
+func (v PoolView) Keys() []string {
+	live, _ := v.c.Keys()
+
+	sort.Strings(live)
+
+	return live
+}
+
+// Snapshot - returns descriptions of all pool items sorted by key (see SafeDbMapCache.ItemsInfo)
+func (v PoolView) Snapshot() []ItemInfo {
+	return v.c.ItemsInfo()
+}